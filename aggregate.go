@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	aggregateWindow  = flag.Duration("aggregate-window", time.Hour, "span of the event counts served by /aggregates (0 disables them)")
+	aggregateMode    = flag.String("aggregate-mode", "sliding", "how /aggregates windows advance: sliding (the last -aggregate-window, in -aggregate-buckets steps) or tumbling (fixed windows that reset when they end)")
+	aggregateBuckets = flag.Int("aggregate-buckets", 60, "number of time buckets a sliding -aggregate-window is divided into")
+)
+
+// aggBucket counts the events broadcast during one slot of time.
+type aggBucket struct {
+	start  time.Time
+	byType map[string]int
+	byID   map[string]int
+}
+
+// aggregator keeps event counts in a ring of time buckets, so old counts
+// age out as their bucket is reused and reads never scan history.
+type aggregator struct {
+	mu    sync.Mutex
+	width time.Duration
+	ring  []aggBucket
+}
+
+// aggregates holds the counts behind /aggregates; nil when disabled.
+var aggregates *aggregator
+
+// newAggregator sizes the ring for -aggregate-mode. A sliding window spans
+// all buckets; a tumbling one is a single bucket plus the previous window.
+func newAggregator(window time.Duration, mode string, buckets int) *aggregator {
+	if mode == "tumbling" {
+		return &aggregator{width: window, ring: make([]aggBucket, 2)}
+	}
+	return &aggregator{width: max(window/time.Duration(buckets), time.Millisecond), ring: make([]aggBucket, buckets)}
+}
+
+// slot returns the bucket for t, resetting it if it last counted an older
+// slot.
+func (a *aggregator) slot(t time.Time) *aggBucket {
+	start := t.Truncate(a.width)
+	b := &a.ring[int(start.UnixNano()/int64(a.width))%len(a.ring)]
+	if !b.start.Equal(start) {
+		*b = aggBucket{start: start, byType: make(map[string]int), byID: make(map[string]int)}
+	}
+	return b
+}
+
+// add counts msg as broadcast now.
+func (a *aggregator) add(msg SSEMessage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b := a.slot(time.Now())
+	b.byType[msg.Type]++
+	if msg.ID != "" {
+		b.byID[msg.ID]++
+	}
+}
+
+// aggregateView is one window of counts as served by /aggregates.
+type aggregateView struct {
+	From   time.Time      `json:"from"`
+	To     time.Time      `json:"to"`
+	Total  int            `json:"total"`
+	ByType map[string]int `json:"by_type"`
+	ByID   map[string]int `json:"by_id"` // per device or tracker
+}
+
+// sum totals the buckets whose slot starts in [from, to).
+func (a *aggregator) sum(from, to time.Time) aggregateView {
+	v := aggregateView{From: from, To: to, ByType: map[string]int{}, ByID: map[string]int{}}
+	for _, b := range a.ring {
+		if b.start.Before(from) || !b.start.Before(to) {
+			continue
+		}
+		for k, n := range b.byType {
+			v.ByType[k] += n
+			v.Total += n
+		}
+		for k, n := range b.byID {
+			v.ByID[k] += n
+		}
+	}
+	return v
+}
+
+// countEvent feeds a broadcast event to the aggregator.
+func countEvent(msg SSEMessage) {
+	if aggregates != nil {
+		aggregates.add(msg)
+	}
+}
+
+// aggregatesHandler returns the event counts per type and per device. A
+// sliding window ends now; a tumbling one is the window in progress, with
+// the last complete one as "previous".
+func aggregatesHandler(w http.ResponseWriter, r *http.Request) error {
+	if aggregates == nil {
+		return newAPIError(http.StatusNotFound, codeNotFound, "Aggregates are disabled")
+	}
+	a := aggregates
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	current := a.slot(now).start
+	if *aggregateMode == "tumbling" {
+		end := current.Add(a.width)
+		return writeJSON(w, r, map[string]any{
+			"mode":     "tumbling",
+			"window":   aggregateWindow.String(),
+			"current":  a.sum(current, end),
+			"previous": a.sum(current.Add(-a.width), current),
+		})
+	}
+	// The oldest bucket is partly outside the window; counting it whole
+	// keeps the window at least -aggregate-window long.
+	from := current.Add(-a.width * time.Duration(len(a.ring)-1))
+	return writeJSON(w, r, map[string]any{
+		"mode":   "sliding",
+		"window": aggregateWindow.String(),
+		"counts": a.sum(from, current.Add(a.width)),
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"flag"
+	"net/http"
+	"strings"
+)
+
+const (
+	codeUnauthorized = "unauthorized"
+	codeForbidden    = "forbidden"
+)
+
+var apiKey = flag.String("api-key", "", "require this key (X-API-Key header, Bearer token or ?api_key=) on write endpoints")
+
+// requestAPIKey extracts the caller's key from the request, preferring
+// headers over the query string.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// hasAPIKey reports whether r presents a credential the -auth backend
+// accepts. It is always true when no -api-key is configured for the static
+// backend.
+func hasAPIKey(r *http.Request) bool {
+	_, ok := authenticator.Authenticate(r)
+	return ok
+}
+
+// requireAPIKey rejects requests without a valid credential and records the
+// caller's identity in the request context.
+func requireAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := authenticator.Authenticate(r)
+		if !ok {
+			writeError(w, newAPIError(http.StatusUnauthorized, codeUnauthorized, "Missing or invalid API key"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+var adminKey = flag.String("admin-key", "", "key required by admin endpoints; they are disabled when unset")
+
+// requireAdmin guards operator-only endpoints. Unlike requireAPIKey it fails
+// closed: without -admin-key every request is refused.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *adminKey == "" {
+			writeError(w, newAPIError(http.StatusForbidden, codeForbidden, "Admin endpoints are disabled"))
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(requestAPIKey(r)), []byte(*adminKey)) != 1 {
+			writeError(w, newAPIError(http.StatusUnauthorized, codeUnauthorized, "Missing or invalid admin key"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
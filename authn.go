@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	authBackend  = flag.String("auth", "static", "how write endpoints authenticate callers: static (-api-key), keys (-api-keys-file) or jwt (Bearer tokens verified with -jwt-secret or -jwt-public-key)")
+	apiKeysFile  = flag.String("api-keys-file", "", `JSON file mapping API keys to caller names for -auth=keys, e.g. {"3f9a…": "gateway-east"}`)
+	jwtSecret    = flag.String("jwt-secret", "", "HS256 secret for -auth=jwt")
+	jwtPublicKey = flag.String("jwt-public-key", "", "PEM file of the RS256 public key for -auth=jwt")
+	jwtIssuer    = flag.String("jwt-issuer", "", "with -auth=jwt, require this iss claim")
+	jwtAudience  = flag.String("jwt-audience", "", "with -auth=jwt, require this aud claim")
+)
+
+// identity is who an authenticated request comes from. Name is empty for
+// the single -api-key, which does not tell callers apart.
+type identity struct {
+	Name string
+}
+
+// An Authenticator checks the credential a request presents.
+type Authenticator interface {
+	// Authenticate returns the caller's identity, or false when r carries
+	// no valid credential.
+	Authenticate(r *http.Request) (identity, bool)
+}
+
+// authenticator is the backend selected by -auth, set by loadAuthenticator.
+var authenticator Authenticator = staticKey{}
+
+// loadAuthenticator builds the backend selected by -auth.
+func loadAuthenticator() error {
+	switch *authBackend {
+	case "static":
+		authenticator = staticKey{*apiKey}
+	case "keys":
+		if *apiKeysFile == "" {
+			return errors.New("-auth=keys requires -api-keys-file")
+		}
+		keys, err := loadAPIKeys(*apiKeysFile)
+		if err != nil {
+			return fmt.Errorf("error loading API keys: %w", err)
+		}
+		authenticator = keys
+	case "jwt":
+		v, err := newJWTVerifier()
+		if err != nil {
+			return err
+		}
+		authenticator = v
+	default:
+		return fmt.Errorf("invalid -auth %q: want static, keys or jwt", *authBackend)
+	}
+	return nil
+}
+
+// authRequired reports whether write endpoints check credentials at all.
+func authRequired() bool {
+	return *authBackend != "static" || *apiKey != ""
+}
+
+// staticKey accepts the one -api-key, or everything when it is empty.
+type staticKey struct {
+	key string
+}
+
+func (s staticKey) Authenticate(r *http.Request) (identity, bool) {
+	if s.key == "" {
+		return identity{}, true
+	}
+	return identity{}, subtle.ConstantTimeCompare([]byte(requestAPIKey(r)), []byte(s.key)) == 1
+}
+
+// apiKeys maps each accepted key to the name of its holder.
+type apiKeys map[string]string
+
+func loadAPIKeys(path string) (apiKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys apiKeys
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for key, name := range keys {
+		if key == "" || name == "" {
+			return nil, fmt.Errorf("%s: keys and names must not be empty", path)
+		}
+	}
+	return keys, nil
+}
+
+func (k apiKeys) Authenticate(r *http.Request) (identity, bool) {
+	presented := []byte(requestAPIKey(r))
+	for key, name := range k {
+		if subtle.ConstantTimeCompare(presented, []byte(key)) == 1 {
+			return identity{Name: name}, true
+		}
+	}
+	return identity{}, false
+}
+
+// jwtVerifier accepts compact JWTs signed with HS256 or RS256, whichever
+// key was configured; the token's alg must match it. The identity is the
+// token's sub claim.
+type jwtVerifier struct {
+	alg    string
+	secret []byte
+	public *rsa.PublicKey
+}
+
+func newJWTVerifier() (*jwtVerifier, error) {
+	switch {
+	case *jwtSecret != "" && *jwtPublicKey != "":
+		return nil, errors.New("-auth=jwt takes -jwt-secret or -jwt-public-key, not both")
+	case *jwtSecret != "":
+		return &jwtVerifier{alg: "HS256", secret: []byte(*jwtSecret)}, nil
+	case *jwtPublicKey != "":
+		data, err := os.ReadFile(*jwtPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM block", *jwtPublicKey)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", *jwtPublicKey, err)
+		}
+		public, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an RSA public key", *jwtPublicKey)
+		}
+		return &jwtVerifier{alg: "RS256", public: public}, nil
+	default:
+		return nil, errors.New("-auth=jwt requires -jwt-secret or -jwt-public-key")
+	}
+}
+
+// jwtClaims are the registered claims the verifier checks. Audience may be
+// a string or an array of strings.
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+}
+
+func (v *jwtVerifier) Authenticate(r *http.Request) (identity, bool) {
+	claims, err := v.verify(requestAPIKey(r), time.Now())
+	if err != nil {
+		return identity{}, false
+	}
+	return identity{Name: claims.Subject}, true
+}
+
+func (v *jwtVerifier) verify(token string, now time.Time) (jwtClaims, error) {
+	var claims jwtClaims
+	header, payload, sig, err := splitJWT(token)
+	if err != nil {
+		return claims, err
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil || h.Alg != v.alg {
+		return claims, errors.New("unexpected alg")
+	}
+
+	signed := token[:strings.LastIndexByte(token, '.')]
+	sum := sha256.Sum256([]byte(signed))
+	switch v.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return claims, errors.New("bad signature")
+		}
+	case "RS256":
+		if err := rsa.VerifyPKCS1v15(v.public, crypto.SHA256, sum[:], sig); err != nil {
+			return claims, err
+		}
+	}
+
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, err
+	}
+	switch {
+	case claims.ExpiresAt != nil && now.Unix() >= *claims.ExpiresAt:
+		return claims, errors.New("token expired")
+	case claims.NotBefore != nil && now.Unix() < *claims.NotBefore:
+		return claims, errors.New("token not yet valid")
+	case *jwtIssuer != "" && claims.Issuer != *jwtIssuer:
+		return claims, errors.New("wrong issuer")
+	case *jwtAudience != "" && !hasAudience(claims.Audience, *jwtAudience):
+		return claims, errors.New("wrong audience")
+	}
+	return claims, nil
+}
+
+// splitJWT decodes the three base64url parts of a compact JWT.
+func splitJWT(token string) (header, payload, sig []byte, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, nil, errors.New("not a JWT")
+	}
+	var decoded [3][]byte
+	for i, p := range parts {
+		if decoded[i], err = base64.RawURLEncoding.DecodeString(p); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return decoded[0], decoded[1], decoded[2], nil
+}
+
+func hasAudience(raw json.RawMessage, want string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == want
+	}
+	var many []string
+	json.Unmarshal(raw, &many)
+	for _, aud := range many {
+		if aud == want {
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+// identityFrom returns the identity requireAPIKey resolved for the request
+// in ctx, if any.
+func identityFrom(ctx context.Context) (identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(identity)
+	return id, ok
+}
+
+// describeCaller names the authenticated caller for log lines, or returns
+// "" when the backend does not tell callers apart.
+func describeCaller(ctx context.Context) string {
+	if id, ok := identityFrom(ctx); ok && id.Name != "" {
+		return " by " + id.Name
+	}
+	return ""
+}
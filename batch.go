@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const codeBatchTooLarge = "batch_too_large"
+
+var maxBatch = flag.Int("max-batch", 1000, "most entries a /update/batch or /gps/batch request may carry; larger batches are rejected with 413")
+
+// maxBatchEntryBytes bounds the body size of a batch together with
+// -max-batch, so an oversized request is cut off while it is read.
+const maxBatchEntryBytes = 1 << 10
+
+// deviceEntry is one element of a /update/batch body. Value accepts the
+// same strings as ?value= as well as JSON booleans.
+type deviceEntry struct {
+	ID     string          `json:"id"`
+	Value  json.RawMessage `json:"value"`
+	Reason string          `json:"reason"`
+	TS     string          `json:"ts"`
+	TTL    string          `json:"ttl"`
+}
+
+// values maps e onto the query params of /update, so it is validated
+// exactly like a single update.
+func (e deviceEntry) values() url.Values {
+	q := url.Values{"id": {e.ID}, "reason": {e.Reason}, "ts": {e.TS}, "ttl": {e.TTL}}
+	var b bool
+	var s string
+	if json.Unmarshal(e.Value, &b) == nil {
+		q.Set("value", strconv.FormatBool(b))
+	} else if json.Unmarshal(e.Value, &s) == nil {
+		q.Set("value", s)
+	}
+	return q
+}
+
+// gpsEntry is one element of a /gps/batch body.
+type gpsEntry struct {
+	ID     string   `json:"id"`
+	Lat    *float64 `json:"lat"`
+	Lon    *float64 `json:"lon"`
+	Source string   `json:"source"`
+	TS     string   `json:"ts"`
+	TTL    string   `json:"ttl"`
+}
+
+// values maps e onto the query params of /gps.
+func (e gpsEntry) values() url.Values {
+	q := url.Values{"id": {e.ID}, "source": {e.Source}, "ts": {e.TS}, "ttl": {e.TTL}}
+	if e.Lat != nil {
+		q.Set("lat", strconv.FormatFloat(*e.Lat, 'g', -1, 64))
+	}
+	if e.Lon != nil {
+		q.Set("lon", strconv.FormatFloat(*e.Lon, 'g', -1, 64))
+	}
+	return q
+}
+
+// batchResult summarizes a batch. Entries that were not applied are listed
+// by their index in the request.
+type batchResult struct {
+	Accepted int          `json:"accepted"`
+	Pending  int          `json:"pending,omitempty"` // held back by hysteresis
+	Stale    int          `json:"stale,omitempty"`
+	Errors   []batchError `json:"errors"`
+}
+
+type batchError struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// readBatch decodes a JSON array body into entries, refusing batches of
+// more than -max-batch entries with 413.
+func readBatch[E any](w http.ResponseWriter, r *http.Request) ([]E, error) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return nil, newAPIError(http.StatusMethodNotAllowed, codeBadMethod, "Use POST")
+	}
+	tooLarge := newAPIError(http.StatusRequestEntityTooLarge, codeBatchTooLarge, fmt.Sprintf("Batches are limited to %d entries", *maxBatch))
+
+	var entries []E
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, int64(*maxBatch)*maxBatchEntryBytes))
+	if err := dec.Decode(&entries); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return nil, tooLarge
+		}
+		return nil, newAPIError(http.StatusBadRequest, codeInvalidParam, "Body must be a JSON array of entries: "+err.Error())
+	}
+	if len(entries) > *maxBatch {
+		return nil, tooLarge
+	}
+	return entries, nil
+}
+
+// processBatch applies record to every entry in order. Each entry takes
+// and releases the state lock on its own, so concurrent single updates
+// interleave with a large batch rather than waiting for all of it.
+func processBatch[E any](entries []E, record func(E) error, id func(E) string) batchResult {
+	res := batchResult{Errors: []batchError{}}
+	for i, e := range entries {
+		err := record(e)
+		var stale *staleError
+		var held *heldError
+		switch {
+		case err == nil:
+			res.Accepted++
+		case errors.As(err, &held):
+			res.Pending++
+		case errors.As(err, &stale):
+			res.Stale++
+			if *staleUpdates == "reject" {
+				res.Errors = append(res.Errors, batchError{i, id(e), err.Error()})
+			}
+		default:
+			var apiErr *apiError
+			msg := err.Error()
+			if errors.As(err, &apiErr) {
+				msg = apiErr.Message
+			}
+			res.Errors = append(res.Errors, batchError{i, id(e), msg})
+		}
+	}
+	return res
+}
+
+// updateBatchHandler applies many device updates from one request. Each
+// entry goes through recordDevice and is broadcast like a single /update.
+func updateBatchHandler(w http.ResponseWriter, r *http.Request) error {
+	entries, err := readBatch[deviceEntry](w, r)
+	if err != nil {
+		return err
+	}
+	res := processBatch(entries, func(e deviceEntry) error {
+		p, err := validate(e.values(), deviceValidators)
+		if err != nil {
+			return err
+		}
+		return recordDevice(r.Context(), p.device())
+	}, func(e deviceEntry) string { return e.ID })
+
+	log.Printf("Batch of %d device update(s): %d accepted, %d failed", len(entries), res.Accepted, len(res.Errors))
+	return writeJSON(w, r, res)
+}
+
+// gpsBatchHandler is updateBatchHandler for GPS fixes.
+func gpsBatchHandler(w http.ResponseWriter, r *http.Request) error {
+	entries, err := readBatch[gpsEntry](w, r)
+	if err != nil {
+		return err
+	}
+	res := processBatch(entries, func(e gpsEntry) error {
+		p, err := validate(e.values(), gpsValidators)
+		if err != nil {
+			return err
+		}
+		return recordGPS(r.Context(), p.location())
+	}, func(e gpsEntry) string { return e.ID })
+
+	log.Printf("Batch of %d GPS fix(es): %d accepted, %d failed", len(entries), res.Accepted, len(res.Errors))
+	return writeJSON(w, r, res)
+}
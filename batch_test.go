@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// An oversized batch is refused with 413 before any of it is applied,
+// whether it has too many entries or too many bytes.
+func TestOversizedBatchAppliesNothing(t *testing.T) {
+	setFlag(t, maxBatch, 3)
+	entries := func(n int, format string) string {
+		var parts []string
+		for i := range n {
+			parts = append(parts, fmt.Sprintf(format, i))
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	}
+	for _, tc := range []struct {
+		name string
+		h    http.Handler
+		body string
+	}{
+		{"devices", apiHandler(updateBatchHandler), entries(4, `{"id":"dev-%d","value":true}`)},
+		{"locations", apiHandler(gpsBatchHandler), entries(4, `{"id":"trk-%d","lat":1,"lon":2}`)},
+		{"device bytes", apiHandler(updateBatchHandler), `[{"id":"dev-0","value":true},{"id":"dev-1","value":true,"reason":"` + strings.Repeat("x", 4*maxBatchEntryBytes) + `"}]`},
+		{"location bytes", apiHandler(gpsBatchHandler), `[{"id":"trk-0","lat":1,"lon":2},{"id":"trk-1","lat":1,"lon":2,"source":"` + strings.Repeat("x", 4*maxBatchEntryBytes) + `"}]`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetState(t)
+			w := serve(tc.h, http.MethodPost, "/batch", strings.NewReader(tc.body))
+			var apiErr apiError
+			json.Unmarshal(w.Body.Bytes(), &apiErr)
+			if w.Code != http.StatusRequestEntityTooLarge || apiErr.Code != codeBatchTooLarge {
+				t.Errorf("status %d, code %q, want 413 %s", w.Code, apiErr.Code, codeBatchTooLarge)
+			}
+			historyMutex.Lock()
+			stored := len(history)
+			historyMutex.Unlock()
+			if devices.len() != 0 || gpsLocations.len() != 0 || stored != 0 {
+				t.Errorf("applied %d devices, %d locations and %d events from a refused batch", devices.len(), gpsLocations.len(), stored)
+			}
+		})
+	}
+
+	resetState(t)
+	w := serve(apiHandler(updateBatchHandler), http.MethodPost, "/batch", strings.NewReader(entries(3, `{"id":"dev-%d","value":true}`)))
+	if w.Code != http.StatusOK || devices.len() != 3 {
+		t.Errorf("batch at the limit: status %d, %d devices stored, want 200 and 3", w.Code, devices.len())
+	}
+}
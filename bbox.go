@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// bbox is a geographic bounding box. West may exceed east for boxes that
+// cross the antimeridian.
+type bbox struct {
+	South, West, North, East float64
+}
+
+// parseBBox parses "south,west,north,east" in decimal degrees.
+func parseBBox(s string) (*bbox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, invalidParam("bbox")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, invalidParam("bbox")
+		}
+		v[i] = f
+	}
+	b := &bbox{South: v[0], West: v[1], North: v[2], East: v[3]}
+	if !validCoordinate(b.South, 90) || !validCoordinate(b.North, 90) || b.South > b.North ||
+		!validCoordinate(b.West, 180) || !validCoordinate(b.East, 180) {
+		return nil, invalidParam("bbox")
+	}
+	return b, nil
+}
+
+func (b *bbox) contains(lat, lon float64) bool {
+	if lat < b.South || lat > b.North {
+		return false
+	}
+	if b.West <= b.East {
+		return lon >= b.West && lon <= b.East
+	}
+	return lon >= b.West || lon <= b.East
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestParseBBox(t *testing.T) {
+	for _, tc := range []struct {
+		bbox string
+		ok   bool
+	}{
+		{"52.4,13.3,52.6,13.5", true},
+		{"-90,-180,90,180", true},
+		{"10,170,20,-170", true}, // crosses the antimeridian
+		{"52.6,13.3,52.4,13.5", false},
+		{"-91,0,0,1", false},
+		{"0,0,91,1", false},
+		{"0,-181,1,1", false},
+		{"0,0,1,181", false},
+		{"NaN,0,1,1", false},
+		{"0,NaN,1,1", false},
+		{"0,0,NaN,1", false},
+		{"0,0,1,NaN", false},
+		{"0,0,+Inf,1", false},
+		{"0,0,1", false},
+		{"a,b,c,d", false},
+	} {
+		if _, err := parseBBox(tc.bbox); (err == nil) != tc.ok {
+			t.Errorf("bbox=%s: error %v, want ok=%v", tc.bbox, err, tc.ok)
+		}
+	}
+}
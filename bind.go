@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"time"
+)
+
+var (
+	bindRetry         = flag.Int("bind-retry", 0, "times to retry binding a listener whose port is in use, e.g. by a predecessor still shutting down (0 fails immediately)")
+	bindRetryInterval = flag.Duration("bind-retry-interval", time.Second, "wait before the first -bind-retry attempt; it doubles after each one")
+)
+
+// listen binds addr, retrying up to -bind-retry times with exponential
+// backoff so a rolling restart can wait for the old process to release the
+// port.
+func listen(addr string) (net.Listener, error) {
+	wait := *bindRetryInterval
+	for attempt := 0; ; attempt++ {
+		ln, err := net.Listen("tcp", addr)
+		if err == nil || attempt >= *bindRetry {
+			return ln, err
+		}
+		warnf("Binding %s failed (attempt %d of %d): %v; retrying in %s", addr, attempt+1, *bindRetry+1, err, wait)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
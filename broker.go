@@ -0,0 +1,510 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	sseIdleTimeout      = flag.Duration("sse-idle-timeout", 0, "close SSE clients whose socket has not accepted a flush within this duration (0 disables)")
+	clientStatsInterval = flag.Duration("client-stats-interval", 5*time.Second, "how often /events?stats=true clients are sent their own delivery counts")
+	duplicateClients    = flag.String("duplicate-clients", "allow", "what a new /events connection does to an open one with the same client id: allow both or replace the old one")
+	sseMaxLifetime      = flag.Duration("sse-max-lifetime", 0, "close SSE connections after this long, sending a reconnect event first, so clients rebalance across instances (0 disables)")
+	ssePadding          = flag.Int("sse-padding", 0, "bytes of comment padding sent when an SSE stream opens, to push it through buffering proxies (0 disables)")
+)
+
+// clientBuffer is how many events may queue for a subscriber before further
+// events are dropped for it.
+const clientBuffer = 16
+
+// notifierQueue is how many broadcasts may wait for the fan-out loop before
+// broadcasters block. Its depth shows how far fan-out is falling behind.
+const notifierQueue = 64
+
+// client is a single SSE subscriber. Clients with a higher priority are
+// written to first during fan-out. A client with a channel only receives
+// events on that channel, one with ids no events about other ids, and a
+// client with a profile receives events rewritten by it, optionally wrapped
+// as CloudEvents.
+type client struct {
+	ch          chan encodedEvent
+	addr        string // remote address, empty for in-process subscribers
+	id          string // stable id the client declared, if any
+	priority    int
+	channel     string
+	bbox        *bbox           // only gps events inside it are delivered
+	ids         map[string]bool // events about other ids are not delivered
+	profile     *transformProfile
+	cloudEvents bool
+	delivered   atomic.Int64 // events written to the connection
+	dropped     atomic.Int64 // events discarded because ch was full
+	replaced    atomic.Bool  // closed by a newer connection with the same id
+	started     time.Time    // when the client subscribed
+	latency     clientLatency
+}
+
+// encodedEvent is an event rendered for one client, with the history
+// sequence number it was stored under (0 for events that were not).
+type encodedEvent struct {
+	seq    uint64
+	data   []byte
+	msg    *SSEMessage // the event before encoding, shared by every client
+	sent   time.Time   // broadcast time, with -delivery-timing
+	queued time.Time   // when fan-out queued it for the client
+}
+
+// deliveryStats is a client's view of its own connection quality, sent to
+// /events?stats=true subscribers.
+type deliveryStats struct {
+	Delivered int64   `json:"delivered"`
+	Dropped   int64   `json:"dropped"`
+	Rate      float64 `json:"rate"` // events delivered per second since the last report
+	Buffered  int     `json:"buffered"`
+	Capacity  int     `json:"capacity"`
+}
+
+// encoding identifies one of the forms an event is delivered in.
+type encoding struct {
+	profile     *transformProfile
+	cloudEvents bool
+}
+
+// encode renders msg, whose native encoding is event, in form e.
+func (e encoding) encode(msg SSEMessage, event []byte) ([]byte, error) {
+	data, err := e.profile.apply(event)
+	if err != nil || !e.cloudEvents {
+		return data, err
+	}
+	return toCloudEvent(msg, data)
+}
+
+// wants reports whether msg should be delivered to c. The ids filter only
+// applies to events about an id; broker-wide events such as notes, clears
+// and system events reach every client on their channel.
+func (c *client) wants(msg SSEMessage) bool {
+	if c.channel != "" && c.channel != msg.Channel {
+		return false
+	}
+	if c.ids != nil && msg.ID != "" && !c.ids[msg.ID] {
+		return false
+	}
+	if c.bbox != nil && msg.Type == "gps" && msg.Lat != nil && msg.Lon != nil {
+		return c.bbox.contains(*msg.Lat, *msg.Lon)
+	}
+	return true
+}
+
+// Broker manages SSE clients
+type Broker struct {
+	Notifier       chan SSEMessage
+	newClients     chan *client
+	closingClients chan *client
+	clients        map[*client]bool
+	ordered        []*client                 // clients sorted by descending priority
+	published      atomic.Pointer[[]*client] // copy of ordered, readable outside listen
+	byID           map[string]*client        // newest client declaring each id
+	ready          chan struct{}             // closed once listen is consuming
+	count          atomic.Int64
+}
+
+func NewBroker() *Broker {
+	broker := &Broker{
+		Notifier:       make(chan SSEMessage, notifierQueue),
+		newClients:     make(chan *client),
+		closingClients: make(chan *client),
+		clients:        make(map[*client]bool),
+		byID:           make(map[string]*client),
+		ready:          make(chan struct{}),
+	}
+	go broker.listen()
+	// Nothing can be broadcast or subscribed until the loop runs, so
+	// callers never start against a broker that is not consuming.
+	<-broker.ready
+	return broker
+}
+
+// ClientCount returns the number of connected subscribers.
+func (broker *Broker) ClientCount() int {
+	return int(broker.count.Load())
+}
+
+// subscribers returns the connected clients in fan-out order. It reads
+// the copy reorder publishes, so it never waits on listen.
+func (broker *Broker) subscribers() []*client {
+	if p := broker.published.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// Inspect returns a snapshot of every subscriber in fan-out order.
+func (broker *Broker) Inspect() []clientState {
+	clients := broker.subscribers()
+	states := make([]clientState, 0, len(clients))
+	for _, c := range clients {
+		addr := c.addr
+		if addr == "" {
+			addr = "in-process"
+		}
+		states = append(states, clientState{
+			Addr:      addr,
+			ClientID:  c.id,
+			Channel:   c.channel,
+			Priority:  c.priority,
+			Buffered:  len(c.ch),
+			Capacity:  cap(c.ch),
+			Delivered: c.delivered.Load(),
+			Dropped:   c.dropped.Load(),
+			Age:       time.Since(c.started).Round(time.Second).String(),
+			Latency:   c.latency.state(),
+		})
+	}
+	return states
+}
+
+// reorder rebuilds the fan-out order after the client set changes.
+func (broker *Broker) reorder() {
+	broker.ordered = broker.ordered[:0]
+	for c := range broker.clients {
+		broker.ordered = append(broker.ordered, c)
+	}
+	slices.SortStableFunc(broker.ordered, func(a, b *client) int {
+		return cmp.Compare(b.priority, a.priority)
+	})
+	broker.count.Store(int64(len(broker.clients)))
+	published := slices.Clone(broker.ordered)
+	broker.published.Store(&published)
+}
+
+// remove deregisters c. Nothing sends to c after this point, so consumers
+// ranging over its channel see it end.
+func (broker *Broker) remove(c *client) {
+	delete(broker.clients, c)
+	if broker.byID[c.id] == c {
+		delete(broker.byID, c.id)
+	}
+	broker.reorder()
+	close(c.ch)
+}
+
+func (broker *Broker) listen() {
+	close(broker.ready)
+	for {
+		select {
+		case c := <-broker.newClients:
+			if old := broker.byID[c.id]; old != nil && *duplicateClients == "replace" {
+				old.replaced.Store(true)
+				broker.remove(old)
+				log.Printf("Client %s reconnected, closing its previous connection", c.id)
+			}
+			if c.id != "" {
+				broker.byID[c.id] = c
+			}
+			broker.clients[c] = true
+			broker.reorder()
+			log.Printf("Client added. Total: %d", len(broker.clients))
+		case c := <-broker.closingClients:
+			if !broker.clients[c] {
+				continue
+			}
+			broker.remove(c)
+			log.Printf("Client removed. Total: %d", len(broker.clients))
+		case msg := <-broker.Notifier:
+			span := startFanout(msg)
+			event, _ := json.Marshal(msg)
+			event = renameEvent(event)
+			delivered := 0
+			// Each encoding is computed at most once per event.
+			encoded := map[encoding][]byte{{}: event}
+			for _, c := range broker.ordered {
+				if !c.wants(msg) {
+					continue
+				}
+				enc := encoding{c.profile, c.cloudEvents}
+				data, ok := encoded[enc]
+				if !ok {
+					var err error
+					if data, err = enc.encode(msg, event); err != nil {
+						errorf("Encoding event failed: %v", err)
+						data = event
+					}
+					encoded[enc] = data
+				}
+				ev := encodedEvent{seq: msg.Seq, data: data, msg: &msg, sent: msg.sent}
+				if !msg.sent.IsZero() {
+					ev.queued = time.Now()
+				}
+				select {
+				case c.ch <- ev:
+					delivered++
+				default:
+					// Drop message if client is blocked
+					c.dropped.Add(1)
+				}
+			}
+			span.SetAttributes(attribute.Int("fanout.clients", delivered))
+			span.End()
+		}
+	}
+}
+
+// Subscribe registers an in-process consumer of the event stream. It
+// receives every event with the same drop-when-full semantics as HTTP
+// clients. The returned func unsubscribes and may be called more than once.
+func (broker *Broker) Subscribe() (<-chan encodedEvent, func()) {
+	return broker.subscribe(&client{})
+}
+
+// subscribe registers c and returns its event channel and unsubscribe func.
+func (broker *Broker) subscribe(c *client) (<-chan encodedEvent, func()) {
+	c.ch = make(chan encodedEvent, clientBuffer)
+	c.started = time.Now()
+	broker.newClients <- c
+
+	var once sync.Once
+	return c.ch, func() {
+		once.Do(func() { broker.closingClients <- c })
+	}
+}
+
+// extendWriteDeadline gives the next write to an SSE connection
+// -sse-idle-timeout to complete. A client that stops reading eventually
+// fills the socket buffer; the deadline turns that stall into a write error.
+// Every write must extend it, or a deadline set for an earlier one expires
+// under a healthy client.
+func extendWriteDeadline(rc *http.ResponseController) {
+	if *sseIdleTimeout > 0 {
+		rc.SetWriteDeadline(time.Now().Add(*sseIdleTimeout))
+	}
+}
+
+// setSSEHeaders prepares w for a Server-Sent Events stream.
+func setSSEHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+}
+
+// writePadding sends -sse-padding bytes of SSE comment. Some proxies hold
+// back the first few kilobytes of a response, which would delay the first
+// events by seconds; clients ignore comments. The caller flushes.
+func writePadding(w http.ResponseWriter) {
+	if *ssePadding <= 0 {
+		return
+	}
+	// A comment line is ":" and the padding, then a newline; the blank
+	// line keeps it separate from the next event.
+	fmt.Fprintf(w, ":%s\n\n", strings.Repeat(" ", max(*ssePadding-3, 0)))
+}
+
+func (broker *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	priority := 0
+	if p := r.URL.Query().Get("priority"); p != "" {
+		var err error
+		priority, err = strconv.Atoi(p)
+		if err != nil {
+			writeError(w, invalidParam("priority"))
+			return
+		}
+	}
+
+	channel := r.URL.Query().Get("channel")
+	if channel != "" && !channelName.MatchString(channel) {
+		writeError(w, invalidParam("channel"))
+		return
+	}
+
+	var profile *transformProfile
+	if name := r.URL.Query().Get("profile"); name != "" {
+		if profile = transformProfiles[name]; profile == nil {
+			writeError(w, newAPIError(http.StatusBadRequest, codeInvalidParam, "Unknown profile: "+name))
+			return
+		}
+	}
+
+	var box *bbox
+	if b := r.URL.Query().Get("bbox"); b != "" {
+		var err error
+		if box, err = parseBBox(b); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	ce, err := wantsCloudEvents(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ids, err := parseIDs(r.URL.Query().Get("ids"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	clientID := r.Header.Get("X-Client-ID")
+	if q := r.URL.Query().Get("client_id"); q != "" {
+		clientID = q
+	}
+
+	lastID, replay, err := lastEventID(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	wantStats, _ := strconv.ParseBool(r.URL.Query().Get("stats"))
+
+	setSSEHeaders(w)
+	advertiseReconnect(w)
+	writePadding(w)
+
+	rc := http.NewResponseController(w)
+	rc.Flush()
+	c := &client{
+		addr:        r.RemoteAddr,
+		id:          clientID,
+		priority:    priority,
+		channel:     channel,
+		bbox:        box,
+		profile:     profile,
+		cloudEvents: ce,
+		ids:         ids,
+	}
+	if *sseAuthChallenge && !hasAPIKey(r) && !checkSignature(r) && !awaitSSEAuth(w, r, rc, c) {
+		return
+	}
+	events, unsubscribe := broker.subscribe(c)
+	defer unsubscribe()
+
+	// Subscribing first means nothing is missed between the snapshot and
+	// the live stream, at the cost of an event possibly arriving twice.
+	if ids != nil {
+		if err := writeSnapshot(w, rc, c); err != nil {
+			debugf("Closing SSE client %s: snapshot failed: %v", r.RemoteAddr, err)
+			return
+		}
+	}
+
+	if err := writeEvents(w, rc, c, noteEvents()); err != nil {
+		debugf("Closing SSE client %s: note failed: %v", r.RemoteAddr, err)
+		return
+	}
+
+	// Live events up to the end of the replay were already sent by it; the
+	// subscription may have queued some of them too.
+	var replayed uint64
+	if replay == replayResync {
+		if err := writeResync(w, rc, c); err != nil {
+			debugf("Closing SSE client %s: resync failed: %v", r.RemoteAddr, err)
+			return
+		}
+	}
+	if replay != noReplay {
+		if replayed, err = writeReplay(w, rc, c, lastID); err != nil {
+			debugf("Closing SSE client %s: replay failed: %v", r.RemoteAddr, err)
+			return
+		}
+	}
+
+	notify := r.Context().Done()
+
+	// Opt-in reports of the client's own delivery counts; a nil channel
+	// never fires.
+	var statsTick <-chan time.Time
+	if wantStats {
+		ticker := time.NewTicker(*clientStatsInterval)
+		defer ticker.Stop()
+		statsTick = ticker.C
+	}
+	var lastDelivered int64
+
+	// Past -sse-max-lifetime the client is asked to reconnect, which lets
+	// a load balancer move long-lived streams to other instances.
+	var expire <-chan time.Time
+	if *sseMaxLifetime > 0 {
+		timer := time.NewTimer(time.Until(c.started.Add(*sseMaxLifetime)))
+		defer timer.Stop()
+		expire = timer.C
+	}
+
+	for {
+		select {
+		case <-notify:
+			return
+		case <-expire:
+			writeControlEvent(w, rc, c, SSEMessage{
+				Type:    "reconnect",
+				Message: fmt.Sprintf("Connection reached its maximum lifetime of %s; reconnect", *sseMaxLifetime),
+				Channel: channelSystem,
+				Time:    time.Now(),
+			})
+			debugf("Closing SSE client %s: maximum lifetime reached", r.RemoteAddr)
+			return
+		case <-statsTick:
+			delivered := c.delivered.Load()
+			stats := &deliveryStats{
+				Delivered: delivered,
+				Dropped:   c.dropped.Load(),
+				Rate:      float64(delivered-lastDelivered) / clientStatsInterval.Seconds(),
+				Buffered:  len(c.ch),
+				Capacity:  cap(c.ch),
+			}
+			lastDelivered = delivered
+			msg := SSEMessage{
+				Type:    "client-stats",
+				Stats:   stats,
+				Message: fmt.Sprintf("Receiving %.1f/s, dropped %d", stats.Rate, stats.Dropped),
+				Channel: channelSystem,
+				Time:    time.Now(),
+			}
+			if err := writeControlEvent(w, rc, c, msg); err != nil {
+				debugf("Closing SSE client %s: write failed: %v", r.RemoteAddr, err)
+				return
+			}
+		case ev, ok := <-events:
+			if !ok {
+				if c.replaced.Load() {
+					// Tell a client that is still alive not to reconnect
+					// and take the stream back.
+					writeControlEvent(w, rc, c, SSEMessage{
+						Type:    "replaced",
+						Message: "A newer connection with this client id took over",
+						Channel: channelSystem,
+						Time:    time.Now(),
+					})
+				}
+				return
+			}
+			if ev.seq != 0 && ev.seq <= replayed {
+				continue
+			}
+			extendWriteDeadline(rc)
+			// Returning deregisters the client, so a dead connection stops
+			// receiving fan-out as soon as a write fails.
+			if err := writeEvent(w, ev); err != nil {
+				debugf("Closing SSE client %s: write failed: %v", r.RemoteAddr, err)
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				debugf("Closing SSE client %s: flush failed: %v", r.RemoteAddr, err)
+				return
+			}
+			c.delivered.Add(1)
+			c.latency.observe(ev)
+		}
+	}
+}
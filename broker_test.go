@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseStream connects to the broker over HTTP and returns the data lines it
+// receives.
+func sseStream(t *testing.T, query string) <-chan string {
+	t.Helper()
+	_, lines := openSSE(t, broker, query)
+	return lines
+}
+
+// openSSE serves h, requests /events?query from it and returns the response
+// along with the data lines read from its body.
+func openSSE(t *testing.T, h http.Handler, query string) (*http.Response, <-chan string) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	resp, err := http.Get(srv.URL + "/events?" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	lines := make(chan string, 64)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+				lines <- data
+			}
+		}
+	}()
+	return resp, lines
+}
+
+// nextEvent returns the next data line, failing the test if none arrives
+// within a second.
+func nextEvent(t *testing.T, lines <-chan string) string {
+	t.Helper()
+	select {
+	case line, ok := <-lines:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("no event within 1s")
+	}
+	return ""
+}
+
+// waitForClients waits until the broker has n subscribers.
+func waitForClients(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for broker.ClientCount() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("broker has %d clients, want %d", broker.ClientCount(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// quiesce waits until b has fanned out every queued event, so whatever its
+// loop read doing so happens before the caller's next step.
+func quiesce(t *testing.T, b *Broker) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(b.Notifier) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("broker did not drain its queue within 2s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// The loop receives the subscription only after the fan-out before it.
+	_, unsubscribe := b.subscribe(&client{})
+	unsubscribe()
+}
+
+// Control events written between broadcasts must extend the idle deadline
+// set for the last broadcast, or a healthy client is cut off.
+func TestControlEventsExtendWriteDeadline(t *testing.T) {
+	resetState(t)
+	setFlag(t, sseIdleTimeout, 200*time.Millisecond)
+	setFlag(t, clientStatsInterval, 300*time.Millisecond)
+
+	lines := sseStream(t, "stats=true")
+	waitForClients(t, 1)
+	broadcast("system", "sets the deadline")
+
+	var stats int
+	timeout := time.After(1100 * time.Millisecond)
+	for stats < 3 {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("stream closed after %d client-stats event(s)", stats)
+			}
+			if strings.Contains(line, `"type":"client-stats"`) {
+				stats++
+			}
+		case <-timeout:
+			t.Fatalf("got %d client-stats event(s), want 3", stats)
+		}
+	}
+}
+
+// The ids filter only narrows events about an id; broker-wide events still
+// reach a client that sets it.
+func TestIDsFilterKeepsBrokerWideEvents(t *testing.T) {
+	resetState(t)
+	lines := sseStream(t, "ids=a")
+	waitForClients(t, 1)
+
+	broadcastFor("b", "update", "b changed")
+	broadcast("note", "maintenance at noon")
+	broadcast("clear", "Logs cleared")
+	broadcastFor("a", "update", "a changed")
+
+	var got []string
+	for {
+		var msg SSEMessage
+		if err := json.Unmarshal([]byte(nextEvent(t, lines)), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == "update" || msg.Type == "note" || msg.Type == "clear" {
+			got = append(got, msg.Type+":"+msg.ID)
+		}
+		if msg.ID == "a" {
+			break
+		}
+	}
+	if want := []string{"note:", "clear:", "update:a"}; !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+// A broker is consuming as soon as NewBroker returns: subscribing and
+// broadcasting straight away must neither block nor lose the event.
+func TestBroadcastRightAfterNewBroker(t *testing.T) {
+	for range 100 {
+		done := make(chan bool)
+		go func() {
+			b := NewBroker()
+			events, unsubscribe := b.subscribe(&client{})
+			defer unsubscribe()
+			b.Notifier <- SSEMessage{Type: "note", Message: "first", Channel: channelSystem}
+			select {
+			case ev := <-events:
+				done <- ev.msg != nil && ev.msg.Message == "first"
+			case <-time.After(time.Second):
+				done <- false
+			}
+		}()
+		select {
+		case ok := <-done:
+			if !ok {
+				t.Fatal("event broadcast right after NewBroker was not delivered")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("subscribing or broadcasting right after NewBroker blocked")
+		}
+	}
+}
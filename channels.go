@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// Default channels for the built-in event types. Subscribers that do not
+// pick a channel receive every channel.
+const (
+	channelAttendance = "attendance"
+	channelGPS        = "gps"
+	channelSystem     = "system"
+)
+
+const maxPublishBytes = 64 << 10
+
+var channelName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// channelFor maps a built-in event type to its default channel.
+func channelFor(msgType string) string {
+	switch msgType {
+	case "update":
+		return channelAttendance
+	case "gps":
+		return channelGPS
+	default:
+		return channelSystem
+	}
+}
+
+// publishHandler broadcasts an arbitrary message on a named channel. The
+// message is the request body, or the message param when the body is empty.
+func publishHandler(w http.ResponseWriter, r *http.Request) error {
+	channel := r.URL.Query().Get("channel")
+	if channel == "" {
+		return missingParam("channel")
+	}
+	if !channelName.MatchString(channel) {
+		return invalidParam("channel")
+	}
+
+	msgType := r.URL.Query().Get("type")
+	if msgType == "" {
+		msgType = "message"
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPublishBytes+1))
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, codeInvalidParam, "Failed to read body")
+	}
+	if len(body) > maxPublishBytes {
+		return newAPIError(http.StatusRequestEntityTooLarge, codeInvalidParam, "Message too large")
+	}
+	message := string(body)
+	if message == "" {
+		message = r.URL.Query().Get("message")
+	}
+	if message == "" {
+		return missingParam("message")
+	}
+
+	log.Printf("Published %s on channel %s", msgType, channel)
+	broadcastMessage(SSEMessage{Type: msgType, Message: message, Channel: channel, Time: time.Now()})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Published"))
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Timestamps come in two kinds. Those the server takes itself with time.Now
+// carry a monotonic clock reading, so the difference between two of them is
+// unaffected by the wall clock being stepped, as happens when NTP first
+// syncs on a board without an RTC. Client ?ts= values and timestamps
+// restored from -state-file are wall clock only, and comparing one with a
+// server timestamp is off by however far the clock has been stepped since.
+
+// now is the server clock used to stamp updates. Tests replace it to step
+// the wall clock.
+var now = time.Now
+
+// processStart anchors the monotonic readings of sinceStart.
+var processStart = time.Now()
+
+// sinceStart returns the monotonic time since the process started, for
+// instants that must be stored as a number.
+func sinceStart() time.Duration {
+	return time.Since(processStart)
+}
+
+// serverStamped reports whether t was taken by time.Now in this process.
+// Round(0) strips the monotonic reading, so only such times change.
+func serverStamped(t time.Time) bool {
+	return t != t.Round(0)
+}
+
+// elapsed returns to - from. It reports false when only one of them is
+// server-stamped, since the difference then mixes clocks that may disagree.
+func elapsed(from, to time.Time) (time.Duration, bool) {
+	if serverStamped(from) != serverStamped(to) {
+		return 0, false
+	}
+	return to.Sub(from), true
+}
+
+// olderThan reports whether an update stamped t is older than the stored
+// stamp prev. A server-stamped update is never older than a stamp that is
+// not: the server has just received it, and a stepped clock must not make
+// it look stale.
+func olderThan(t, prev time.Time) bool {
+	if serverStamped(t) && !serverStamped(prev) {
+		return false
+	}
+	return t.Before(prev)
+}
+
+// timeHandler reports the server's wall clock, so a client with a bad clock
+// can compute its skew before sending ?ts= values. It needs no key, and
+// successful requests are never logged since clients may poll it.
+func timeHandler(w http.ResponseWriter, r *http.Request) error {
+	t := now()
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, r, map[string]any{
+		"server_time": t.UTC().Format(time.RFC3339Nano),
+		"unix_ms":     t.UnixMilli(),
+	})
+}
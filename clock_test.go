@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// stepClock moves the server clock by d for the rest of the test, as NTP
+// does when it first syncs a board without a real-time clock. Server
+// stamps keep their monotonic readings, so only comparisons with wall-only
+// stamps see the step.
+func stepClock(t *testing.T, d time.Duration) {
+	t.Helper()
+	setFlag(t, &now, func() time.Time { return time.Now().Add(d) })
+}
+
+// Updates stamped by the server after a clock jump must not be compared with
+// wall-only stamps from before it: they are never stale against them and
+// raise no speed alert or gap.
+func TestClockJump(t *testing.T) {
+	for _, step := range []time.Duration{-time.Hour, time.Hour} {
+		t.Run(step.String(), func(t *testing.T) {
+			resetState(t)
+			setFlag(t, speedLimit, 1)
+			setFlag(t, gpsGap, time.Minute)
+			ts := time.Now().UTC().Format(time.RFC3339Nano)
+
+			// Written with the device's own clock before the jump.
+			if w := serve(apiHandler(updateHandler), http.MethodGet, "/update?id=d&value=true&ts="+ts, nil); w.Code != http.StatusOK {
+				t.Fatalf("/update with ts: %d %s", w.Code, w.Body)
+			}
+			if w := serve(apiHandler(gpsHandler), http.MethodGet, "/gps?id=g&lat=0&lon=0&ts="+ts, nil); w.Code != http.StatusOK {
+				t.Fatalf("/gps with ts: %d %s", w.Code, w.Body)
+			}
+
+			stepClock(t, step)
+			if w := serve(apiHandler(updateHandler), http.MethodGet, "/update?id=d&value=false", nil); w.Code != http.StatusOK {
+				t.Errorf("/update after the jump: %d %s", w.Code, w.Body)
+			}
+			if w := serve(apiHandler(gpsHandler), http.MethodGet, "/gps?id=g&lat=1&lon=1", nil); w.Code != http.StatusOK {
+				t.Errorf("/gps after the jump: %d %s", w.Code, w.Body)
+			}
+
+			if dev, _ := devices.get("d"); dev.Value {
+				t.Error("device update after the jump was not stored")
+			}
+			if loc, _ := gpsLocations.get("g"); loc.Lat != 1 {
+				t.Error("location after the jump was not stored")
+			}
+			historyMutex.Lock()
+			defer historyMutex.Unlock()
+			for _, msg := range history {
+				if msg.Type == "speed-alert" || msg.Type == "gps-uncertain" {
+					t.Errorf("%s raised across the jump: %s", msg.Type, msg.Message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	cloudEvents = flag.Bool("cloudevents", false, "wrap SSE and webhook payloads in a CloudEvents 1.0 JSON envelope by default")
+	publicURL   = flag.String("public-url", "", "externally reachable base URL of this server, used as the CloudEvents source")
+)
+
+// cloudEventTypePrefix namespaces event types, e.g. "meeting.gps".
+const cloudEventTypePrefix = "meeting."
+
+// cloudEvent is the CloudEvents 1.0 structured-mode JSON envelope.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	ID              string          `json:"id"`
+	Time            time.Time       `json:"time"`
+	Subject         string          `json:"subject,omitempty"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// eventSource identifies this server in CloudEvents.
+func eventSource() string {
+	if *publicURL != "" {
+		return *publicURL
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return "urn:host:" + host
+}
+
+// toCloudEvent wraps data, the encoding of msg delivered to a subscriber,
+// in a CloudEvents envelope. Stored events are identified by their sequence
+// number; ephemeral ones by their timestamp.
+func toCloudEvent(msg SSEMessage, data []byte) ([]byte, error) {
+	id := strconv.FormatUint(msg.Seq, 10)
+	if msg.Seq == 0 {
+		id = "t" + strconv.FormatInt(msg.Time.UnixNano(), 10)
+	}
+	return json.Marshal(cloudEvent{
+		SpecVersion:     "1.0",
+		Type:            cloudEventTypePrefix + msg.Type,
+		Source:          eventSource(),
+		ID:              id,
+		Time:            msg.Time,
+		Subject:         msg.ID,
+		DataContentType: "application/json",
+		Data:            data,
+	})
+}
+
+// wantsCloudEvents applies a subscriber's ?format= choice over the
+// -cloudevents default.
+func wantsCloudEvents(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("format") {
+	case "":
+		return *cloudEvents, nil
+	case "native":
+		return false, nil
+	case "cloudevents":
+		return true, nil
+	default:
+		return false, invalidParam("format")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"flag"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+var compression = flag.String("compression", "br,gzip,deflate", "response encodings offered to clients, most preferred first (empty disables compression)")
+
+// encoders builds a compressing writer for each supported Content-Encoding.
+var encoders = map[string]func(io.Writer) io.WriteCloser{
+	"br": func(w io.Writer) io.WriteCloser {
+		// Level 5 is close to gzip in speed with noticeably smaller output.
+		return brotli.NewWriterLevel(w, 5)
+	},
+	"gzip": func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	},
+	"deflate": func(w io.Writer) io.WriteCloser {
+		zw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return zw
+	},
+}
+
+// negotiateEncoding picks the first of offered that Accept-Encoding allows,
+// or "" for identity.
+func negotiateEncoding(accept string, offered []string) string {
+	q := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		if name != "" {
+			q[strings.ToLower(name)] = weight
+		}
+	}
+	for _, enc := range offered {
+		weight, ok := q[enc]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > 0 {
+			return enc
+		}
+	}
+	return ""
+}
+
+// compressWriter compresses the body once the status shows it is a full
+// response; partial, empty and already encoded responses pass through.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	zw          io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	h := cw.Header()
+	if status == http.StatusOK && h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		cw.zw = encoders[cw.encoding](cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.zw == nil {
+		return cw.ResponseWriter.Write(p)
+	}
+	return cw.zw.Write(p)
+}
+
+func (cw *compressWriter) close() {
+	if cw.zw != nil {
+		cw.zw.Close()
+	}
+}
+
+// withCompression encodes responses with the best encoding the client
+// accepts from -compression. It buffers nothing itself but must not wrap
+// streaming endpoints, whose flushes it would not pass on.
+func withCompression(next http.Handler) http.Handler {
+	var offered []string
+	for _, enc := range strings.Split(*compression, ",") {
+		if enc = strings.TrimSpace(enc); encoders[enc] != nil && !slices.Contains(offered, enc) {
+			offered = append(offered, enc)
+		}
+	}
+	if len(offered) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := negotiateEncoding(r.Header.Get("Accept-Encoding"), offered)
+		if enc == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: enc}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
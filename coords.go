@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// gpsDMSView is a location with its coordinates also rendered as
+// degrees-minutes-seconds for human-facing views.
+type gpsDMSView struct {
+	GPSLocation
+	LatDMS string `json:"lat_dms"`
+	LonDMS string `json:"lon_dms"`
+}
+
+// formatLocations applies the ?coord_format= param to locs. Decimal, the
+// default, returns locs unchanged.
+func formatLocations(r *http.Request, locs []GPSLocation) (any, error) {
+	switch r.URL.Query().Get("coord_format") {
+	case "", "decimal":
+		return locs, nil
+	case "dms":
+		views := make([]gpsDMSView, len(locs))
+		for i, loc := range locs {
+			views[i] = gpsDMSView{
+				GPSLocation: loc,
+				LatDMS:      toDMS(loc.Lat, "N", "S"),
+				LonDMS:      toDMS(loc.Lon, "E", "W"),
+			}
+		}
+		return views, nil
+	default:
+		return nil, invalidParam("coord_format")
+	}
+}
+
+// toDMS renders a decimal coordinate as e.g. 40°26′46″N. The sign selects
+// the hemisphere letter; the figures are always positive.
+func toDMS(coord float64, pos, neg string) string {
+	hemi := pos
+	if coord < 0 {
+		hemi = neg
+	}
+
+	// Work in whole seconds so rounding carries into minutes and degrees
+	// instead of producing 60″ or 60′.
+	total := int64(math.Round(math.Abs(coord) * 3600))
+	deg := total / 3600
+	min := total % 3600 / 60
+	sec := total % 60
+	return fmt.Sprintf("%d°%02d′%02d″%s", deg, min, sec, hemi)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var corsMaxAge = flag.Duration("cors-max-age", 5*time.Minute, "how long browsers may cache a CORS preflight result (0 disables caching)")
+
+// withCORS allows cross-origin access to every route and answers preflight
+// requests directly.
+func withCORS(next http.Handler) http.Handler {
+	maxAge := strconv.Itoa(int(corsMaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", "*")
+		h.Set("Access-Control-Expose-Headers", "X-Server-Instance, X-Truncated, X-Next-Cursor, X-Reconnect-Backoff")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Last-Event-ID, X-API-Key, X-Client-ID")
+			h.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"maps"
+	"sync/atomic"
+)
+
+// cowMap is a copy-on-write map keyed by id. Readers take a snapshot without
+// locking, and the snapshot never changes under them; writers copy the map,
+// change the copy and swap it in. Writers must be serialized by the caller,
+// which for devices and gpsLocations is mutex and gpsMutex respectively,
+// since those also guard the state that changes alongside each entry.
+// Every write copies the whole map, so a write costs time proportional to
+// the number of entries: BenchmarkCowMapSet measures it against a locked
+// map. That is cheap for the few hundred devices a site has; code changing
+// many entries at once should use a single update.
+type cowMap[V any] struct {
+	m atomic.Pointer[map[string]V]
+}
+
+// snapshot returns the current contents. It must not be modified.
+func (c *cowMap[V]) snapshot() map[string]V {
+	if p := c.m.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// get returns the value stored under id.
+func (c *cowMap[V]) get(id string) (V, bool) {
+	v, ok := c.snapshot()[id]
+	return v, ok
+}
+
+// len returns the number of entries.
+func (c *cowMap[V]) len() int {
+	return len(c.snapshot())
+}
+
+// update applies fn to a copy of the contents and publishes the result, so
+// a batch of changes costs a single copy.
+func (c *cowMap[V]) update(fn func(m map[string]V)) {
+	m := maps.Clone(c.snapshot())
+	if m == nil {
+		m = make(map[string]V)
+	}
+	fn(m)
+	c.m.Store(&m)
+}
+
+// set stores v under id.
+func (c *cowMap[V]) set(id string, v V) {
+	c.update(func(m map[string]V) { m[id] = v })
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// lockedMap is the RWMutex-guarded map cowMap replaced, kept as a baseline.
+type lockedMap[V any] struct {
+	mu sync.RWMutex
+	m  map[string]V
+}
+
+func (l *lockedMap[V]) get(id string) (V, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	v, ok := l.m[id]
+	return v, ok
+}
+
+func (l *lockedMap[V]) set(id string, v V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.m[id] = v
+}
+
+// benchSizes are tracker counts from a single site to a large fleet.
+var benchSizes = []int{10, 100, 1000, 10000}
+
+func filledCowMap(n int) *cowMap[GPSLocation] {
+	var c cowMap[GPSLocation]
+	c.update(func(m map[string]GPSLocation) {
+		for i := range n {
+			m[strconv.Itoa(i)] = GPSLocation{ID: strconv.Itoa(i)}
+		}
+	})
+	return &c
+}
+
+func filledLockedMap(n int) *lockedMap[GPSLocation] {
+	l := &lockedMap[GPSLocation]{m: make(map[string]GPSLocation)}
+	for i := range n {
+		l.m[strconv.Itoa(i)] = GPSLocation{ID: strconv.Itoa(i)}
+	}
+	return l
+}
+
+func BenchmarkCowMapSet(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			c := filledCowMap(n)
+			for i := range b.N {
+				id := strconv.Itoa(i % n)
+				c.set(id, GPSLocation{ID: id})
+			}
+		})
+	}
+}
+
+func BenchmarkLockedMapSet(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			l := filledLockedMap(n)
+			for i := range b.N {
+				id := strconv.Itoa(i % n)
+				l.set(id, GPSLocation{ID: id})
+			}
+		})
+	}
+}
+
+// Reads run in parallel, as concurrent /locations and /devices requests do.
+func BenchmarkCowMapGet(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			c := filledCowMap(n)
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					c.get(strconv.Itoa(i % n))
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkLockedMapGet(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			l := filledLockedMap(n)
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					l.get(strconv.Itoa(i % n))
+				}
+			})
+		})
+	}
+}
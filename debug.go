@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+)
+
+// clientState describes one subscriber for /debug/state.
+type clientState struct {
+	Addr      string        `json:"addr"`
+	ClientID  string        `json:"client_id,omitempty"`
+	Channel   string        `json:"channel,omitempty"`
+	Priority  int           `json:"priority"`
+	Buffered  int           `json:"buffered"`
+	Capacity  int           `json:"capacity"`
+	Delivered int64         `json:"delivered"`
+	Dropped   int64         `json:"dropped"`
+	Age       string        `json:"age"`               // time since the client subscribed
+	Latency   *latencyState `json:"latency,omitempty"` // with -delivery-timing
+}
+
+// debugStateHandler reports broker internals, for diagnosing leaks and
+// stuck clients.
+func debugStateHandler(w http.ResponseWriter, r *http.Request) error {
+	historyMutex.Lock()
+	replay := map[string]any{"size": len(history), "limit": historyLimit(), "last_seq": lastSeq}
+	if len(history) > 0 {
+		replay["oldest_seq"] = history[0].Seq
+	}
+	historyMutex.Unlock()
+
+	subscribers := broker.Inspect()
+	notifier, fullest := brokerOccupancy()
+
+	return writeJSON(w, r, map[string]any{
+		"goroutines":         runtime.NumGoroutine(),
+		"clients":            broker.ClientCount(),
+		"notifier_queue":     len(broker.Notifier),
+		"notifier_capacity":  cap(broker.Notifier),
+		"notifier_occupancy": notifier,
+		"subscribers":        subscribers,
+		"fullest_buffer":     fullest,
+		"saturated":          saturated.Load(),
+		"replay_buffer":      replay,
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var webhookAllowHosts = flag.String("webhook-allow-hosts", "", "comma-separated hosts that device webhooks may target; any host is allowed when empty")
+
+var (
+	deviceHooksMutex sync.Mutex
+	deviceHooks      = make(map[string]string) // device or tracker id -> webhook URL
+	deviceHooksOnce  sync.Once
+)
+
+// checkHookURL validates a device webhook URL. Restricting hosts with
+// -webhook-allow-hosts keeps callers from pointing the server at internal
+// services.
+func checkHookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return newAPIError(http.StatusBadRequest, codeInvalidParam, "url must be an absolute http or https URL")
+	}
+	if *webhookAllowHosts == "" {
+		return nil
+	}
+	for _, host := range strings.Split(*webhookAllowHosts, ",") {
+		if strings.EqualFold(strings.TrimSpace(host), u.Hostname()) {
+			return nil
+		}
+	}
+	return newAPIError(http.StatusForbidden, codeForbidden, "Host not in -webhook-allow-hosts: "+u.Hostname())
+}
+
+// runDeviceWebhooks forwards each event about a device with its own webhook
+// to that URL, in addition to -webhook-url. Like runWebhook it delivers one
+// event at a time, with the same retries and delivery log.
+func runDeviceWebhooks() {
+	events, _ := broker.subscribe(&client{})
+	for ev := range events {
+		// Match on the event itself: with -field-map the encoded id may
+		// be under another key.
+		msg := ev.msg
+		if msg.ID == "" {
+			continue
+		}
+		deviceHooksMutex.Lock()
+		hook, ok := deviceHooks[msg.ID]
+		deviceHooksMutex.Unlock()
+		if ok {
+			deliver(hook, delivery{Seq: msg.Seq, Type: msg.Type, Device: msg.ID}, ev.data)
+		}
+	}
+}
+
+// deviceWebhooksHandler lists the device webhooks on GET. POST ?id=&url=
+// sets the webhook of id and an empty url removes it.
+func deviceWebhooksHandler(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			return missingParam("id")
+		}
+		hook := r.URL.Query().Get("url")
+		if hook != "" {
+			if err := checkHookURL(hook); err != nil {
+				return err
+			}
+		}
+		deviceHooksMutex.Lock()
+		if hook == "" {
+			delete(deviceHooks, id)
+		} else {
+			deviceHooks[id] = hook
+		}
+		deviceHooksMutex.Unlock()
+		if hook == "" {
+			log.Printf("Webhook for %s removed", id)
+		} else {
+			deviceHooksOnce.Do(func() { go runDeviceWebhooks() })
+			log.Printf("Webhook for %s set", id)
+		}
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		return newAPIError(http.StatusMethodNotAllowed, codeBadMethod, "Use GET or POST")
+	}
+
+	deviceHooksMutex.Lock()
+	out := make([]map[string]string, 0, len(deviceHooks))
+	for id, hook := range deviceHooks {
+		out = append(out, map[string]string{"id": id, "url": hook})
+	}
+	deviceHooksMutex.Unlock()
+	slices.SortFunc(out, func(a, b map[string]string) int { return strings.Compare(a["id"], b["id"]) })
+	return writeJSON(w, r, out)
+}
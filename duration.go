@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"time"
+)
+
+var deviceHistoryLength = flag.Int("device-history", 0, "number of recent value changes kept per device for /duration (0 disables)")
+
+// deviceHistory holds each device's recent states, oldest first. It is
+// guarded by mutex, which also serializes writes to devices.
+var deviceHistory = make(map[string][]DeviceState)
+
+// appendDeviceHistory records dev, trimming to -device-history entries.
+// Callers must hold mutex.
+func appendDeviceHistory(dev DeviceState) {
+	if *deviceHistoryLength <= 0 {
+		return
+	}
+	h := append(deviceHistory[dev.ID], dev)
+	if over := len(h) - *deviceHistoryLength; over > 0 {
+		h = h[over:]
+	}
+	deviceHistory[dev.ID] = h
+}
+
+// presence is one stretch of time a device was present. Open ends mean the
+// device was already present at from, or still present at to.
+type presence struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	OpenStart bool      `json:"open_start,omitempty"`
+	OpenEnd   bool      `json:"open_end,omitempty"`
+}
+
+// presenceWithin returns the intervals in which states, ordered oldest first,
+// had the value true, clipped to [from, to].
+func presenceWithin(states []DeviceState, from, to time.Time) []presence {
+	intervals := []presence{}
+	var cur *presence
+	for _, s := range states {
+		if s.UpdatedAt.After(to) {
+			break
+		}
+		switch {
+		case s.Value && cur == nil:
+			cur = &presence{Start: s.UpdatedAt}
+			if s.UpdatedAt.Before(from) {
+				cur.Start, cur.OpenStart = from, true
+			}
+		case !s.Value && cur != nil:
+			if s.UpdatedAt.After(from) {
+				cur.End = s.UpdatedAt
+				intervals = append(intervals, *cur)
+			}
+			cur = nil
+		}
+	}
+	if cur != nil {
+		cur.End, cur.OpenEnd = to, true
+		intervals = append(intervals, *cur)
+	}
+	return intervals
+}
+
+// durationHandler totals how long a device was present between from and to.
+func durationHandler(w http.ResponseWriter, r *http.Request) error {
+	if *deviceHistoryLength <= 0 {
+		return newAPIError(http.StatusNotFound, codeNotFound, "Device history is disabled; start the server with -device-history")
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return missingParam("id")
+	}
+
+	to := time.Now()
+	if t := r.URL.Query().Get("to"); t != "" {
+		var err error
+		if to, err = time.Parse(time.RFC3339Nano, t); err != nil {
+			return invalidParam("to")
+		}
+	}
+	var from time.Time
+	if f := r.URL.Query().Get("from"); f != "" {
+		var err error
+		if from, err = time.Parse(time.RFC3339Nano, f); err != nil {
+			return invalidParam("from")
+		}
+	}
+	if from.After(to) {
+		return newAPIError(http.StatusBadRequest, codeInvalidParam, "from must not be after to")
+	}
+
+	mutex.Lock()
+	states, ok := deviceHistory[id]
+	intervals := presenceWithin(states, from, to)
+	mutex.Unlock()
+	if !ok {
+		return newAPIError(http.StatusNotFound, codeNotFound, "Unknown device: "+id)
+	}
+
+	var total time.Duration
+	for _, p := range intervals {
+		total += p.End.Sub(p.Start)
+	}
+	return writeJSON(w, r, map[string]any{
+		"id":        id,
+		"from":      from,
+		"to":        to,
+		"seconds":   total.Seconds(),
+		"intervals": intervals,
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	idNamespaces    = flag.String("id-namespaces", "shared", "how device and tracker ids relate: shared (the same id may be both) or separate (an id is either a device or a tracker)")
+	deviceIDPrefix  = flag.String("device-id-prefix", "", "with -id-namespaces=separate, require device ids to start with this prefix")
+	trackerIDPrefix = flag.String("tracker-id-prefix", "", "with -id-namespaces=separate, require tracker ids to start with this prefix")
+)
+
+const codeIDConflict = "id_conflict"
+
+func parseIDNamespaces() error {
+	switch *idNamespaces {
+	case "shared":
+		if *deviceIDPrefix != "" || *trackerIDPrefix != "" {
+			return fmt.Errorf("-device-id-prefix and -tracker-id-prefix require -id-namespaces=separate")
+		}
+	case "separate":
+	default:
+		return fmt.Errorf("invalid -id-namespaces %q: want shared or separate", *idNamespaces)
+	}
+	return nil
+}
+
+// checkDeviceID enforces -id-namespaces=separate for a device write: the id
+// must carry the device prefix and must not already be a tracker.
+func checkDeviceID(id string) error {
+	if *idNamespaces != "separate" {
+		return nil
+	}
+	if !strings.HasPrefix(id, *deviceIDPrefix) {
+		return newAPIError(http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("Device ids must start with %q", *deviceIDPrefix))
+	}
+	_, taken := gpsLocations.get(id)
+	if taken {
+		return newAPIError(http.StatusConflict, codeIDConflict, fmt.Sprintf("%s is already a tracker id", id))
+	}
+	return nil
+}
+
+// checkTrackerID is checkDeviceID for a GPS write.
+func checkTrackerID(id string) error {
+	if *idNamespaces != "separate" {
+		return nil
+	}
+	if !strings.HasPrefix(id, *trackerIDPrefix) {
+		return newAPIError(http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("Tracker ids must start with %q", *trackerIDPrefix))
+	}
+	_, taken := devices.get(id)
+	if taken {
+		return newAPIError(http.StatusConflict, codeIDConflict, fmt.Sprintf("%s is already a device id", id))
+	}
+	return nil
+}
+
+// entityHandler returns whatever is known under an id: its device state, its
+// tracker position, or both when ids are shared.
+func entityHandler(w http.ResponseWriter, r *http.Request) error {
+	id := r.PathValue("id")
+
+	dev, isDevice := devices.get(id)
+	loc, isTracker := gpsLocations.get(id)
+
+	if !isDevice && !isTracker {
+		return newAPIError(http.StatusNotFound, codeNotFound, "Unknown id: "+id)
+	}
+	out := map[string]any{"id": id}
+	if isDevice {
+		dev.Status = deviceStatus(dev)
+		out["device"] = dev
+	}
+	if isTracker {
+		out["tracker"] = loc
+	}
+	return writeJSON(w, r, out)
+}
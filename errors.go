@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Machine-readable error codes returned in the "code" field of error
+// responses. Clients can switch on these instead of parsing messages.
+const (
+	codeMissingParam = "missing_param"
+	codeInvalidParam = "invalid_param"
+	codeUnknownField = "unknown_field"
+	codeStaleUpdate  = "stale_update"
+	codeNotFound     = "not_found"
+	codeBadMethod    = "method_not_allowed"
+	codeInternal     = "internal"
+)
+
+// apiError is an error that knows how it should be reported to the client.
+type apiError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, code, message string) *apiError {
+	return &apiError{Status: status, Code: code, Message: message}
+}
+
+func missingParam(name string) *apiError {
+	return newAPIError(http.StatusBadRequest, codeMissingParam, "Missing "+name+" param")
+}
+
+func invalidParam(name string) *apiError {
+	return newAPIError(http.StatusBadRequest, codeInvalidParam, "Invalid "+name+" param")
+}
+
+// apiHandler is a handler that returns its failure instead of writing it,
+// leaving the response format to writeError.
+type apiHandler func(w http.ResponseWriter, r *http.Request) error
+
+func (h apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h(w, r); err != nil {
+		writeError(w, err)
+	}
+}
+
+// writeError translates err into a JSON error response. Errors that are not
+// an *apiError are logged and reported as a generic 500.
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		errorf("Internal error: %v", err)
+		apiErr = newAPIError(http.StatusInternalServerError, codeInternal, "Internal server error")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(apiErr)
+}
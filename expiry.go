@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	defaultTTL  = flag.Duration("ttl", 0, "remove devices and trackers not updated within this duration (0 keeps them forever)")
+	sweepPeriod = flag.Duration("ttl-sweep-interval", 10*time.Second, "how often expired devices and trackers are removed")
+)
+
+// parseTTL parses the optional per-entry ttl override. Zero means the entry
+// follows -ttl.
+func parseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl < 0 {
+		return 0, invalidParam("ttl")
+	}
+	return ttl, nil
+}
+
+// effectiveTTL prefers an entry's own TTL over the global default.
+func effectiveTTL(ttl time.Duration) time.Duration {
+	if ttl > 0 {
+		return ttl
+	}
+	return *defaultTTL
+}
+
+// expired reports whether an entry last updated at updated has outlived ttl.
+func expired(updated time.Time, ttl time.Duration, now time.Time) bool {
+	ttl = effectiveTTL(ttl)
+	return ttl > 0 && now.Sub(updated) > ttl
+}
+
+// sweepExpired periodically removes devices and trackers whose TTL has
+// elapsed and broadcasts a remove event for each, until ctx is cancelled.
+func sweepExpired(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		removeEntries(
+			func(dev DeviceState) bool { return expired(dev.UpdatedAt, dev.TTL, now) },
+			func(loc GPSLocation) bool { return expired(loc.UpdatedAt, loc.TTL, now) },
+			"expired", "timeout")
+	}
+}
+
+// removeEntries deletes the devices and trackers matched by the predicates,
+// either of which may be nil to leave that kind alone, and announces each
+// removal as e.g. "Device x expired" with the reason code. It returns how
+// many of each were removed.
+func removeEntries(device func(DeviceState) bool, tracker func(GPSLocation) bool, verb, reason string) (int, int) {
+	now := time.Now()
+
+	var goneDevices []string
+	var allGone bool
+	if device != nil {
+		mutex.Lock()
+		devices.update(func(m map[string]DeviceState) {
+			for id, dev := range m {
+				if device(dev) {
+					delete(m, id)
+					delete(pendingChanges, id)
+					// A removed device is no longer present.
+					appendDeviceHistory(DeviceState{ID: id, UpdatedAt: now, Reason: reason})
+					goneDevices = append(goneDevices, id)
+				}
+			}
+			allGone = len(goneDevices) > 0 && len(m) == 0
+		})
+		mutex.Unlock()
+	}
+
+	var goneTrackers []string
+	if tracker != nil {
+		gpsMutex.Lock()
+		gpsLocations.update(func(m map[string]GPSLocation) {
+			for id, loc := range m {
+				if tracker(loc) {
+					delete(m, id)
+					delete(tracks, id)
+					delete(sourceFixes, id)
+					goneTrackers = append(goneTrackers, id)
+				}
+			}
+		})
+		gpsMutex.Unlock()
+		forgetSpeed(goneTrackers)
+		forgetGeofences(goneTrackers)
+	}
+
+	if len(goneDevices)+len(goneTrackers) > 0 {
+		markDirty()
+	}
+	for _, id := range goneDevices {
+		announceRemoval(id, channelAttendance, fmt.Sprintf("Device %s %s", id, verb), reason)
+	}
+	if allGone {
+		announceOccupancy("all-gone", "All devices are gone")
+	}
+	for _, id := range goneTrackers {
+		announceRemoval(id, channelGPS, fmt.Sprintf("Tracker %s %s", id, verb), reason)
+	}
+	return len(goneDevices), len(goneTrackers)
+}
+
+// pruneHandler removes everything not updated within ?older_than= right
+// away, for maintenance windows that cannot wait for the TTL sweeper.
+// ?type= limits it to devices or gps; the default is both.
+func pruneHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return newAPIError(http.StatusMethodNotAllowed, codeBadMethod, "Use POST")
+	}
+	o := r.URL.Query().Get("older_than")
+	if o == "" {
+		return missingParam("older_than")
+	}
+	age, err := time.ParseDuration(o)
+	if err != nil || age < 0 {
+		return invalidParam("older_than")
+	}
+	cutoff := time.Now().Add(-age)
+
+	device := func(dev DeviceState) bool { return dev.UpdatedAt.Before(cutoff) }
+	tracker := func(loc GPSLocation) bool { return loc.UpdatedAt.Before(cutoff) }
+	switch r.URL.Query().Get("type") {
+	case "", "both":
+	case "devices":
+		tracker = nil
+	case "gps":
+		device = nil
+	default:
+		return invalidParam("type")
+	}
+
+	d, t := removeEntries(device, tracker, "pruned", "pruned")
+	log.Printf("Pruned %d device(s) and %d tracker(s) older than %s", d, t, age)
+	return writeJSON(w, r, map[string]int{"devices": d, "trackers": t})
+}
+
+func announceRemoval(id, channel, message, reason string) {
+	log.Println(message)
+	broadcastMessage(SSEMessage{Type: "remove", ID: id, Reason: reason, Message: message, Channel: channel, Time: time.Now()})
+}
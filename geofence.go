@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	geofenceFile    = flag.String("geofence-file", "", `JSON file of circular fences, e.g. [{"id": "depot", "lat": 51.5, "lon": -0.12, "radius": 200}]; trackers crossing them raise geofence-enter and geofence-exit events`)
+	geofenceBuffer  = flag.Float64("geofence-buffer", 0, "meters a tracker must be past a fence boundary before the fix counts as a crossing")
+	geofenceConfirm = flag.Int("geofence-confirm", 1, "consecutive fixes past the boundary needed to confirm a geofence crossing")
+)
+
+// geofence is a circle around a point, with its radius in meters.
+type geofence struct {
+	ID     string  `json:"id"`
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Radius float64 `json:"radius"`
+}
+
+// geofences holds the fences loaded from -geofence-file.
+var geofences []geofence
+
+func loadGeofences(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var fences []geofence
+	if err := json.Unmarshal(data, &fences); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for _, f := range fences {
+		if f.ID == "" || seen[f.ID] {
+			return fmt.Errorf("fence ids must be unique and non-empty, got %q", f.ID)
+		}
+		if f.Radius <= 0 {
+			return fmt.Errorf("fence %q: radius must be positive", f.ID)
+		}
+		seen[f.ID] = true
+	}
+	geofences = fences
+	return nil
+}
+
+// fenceState is a tracker's confirmed side of one fence, and how many
+// consecutive fixes have been seen on the other side.
+type fenceState struct {
+	inside  bool
+	pending int
+}
+
+var (
+	fenceMutex sync.Mutex
+	insideOf   = make(map[string]map[string]*fenceState) // tracker id -> fence id -> state
+)
+
+// crossed reports whether loc is far enough across f's boundary, by
+// -geofence-buffer meters, to count against a tracker whose confirmed side
+// is inside.
+func (f geofence) crossed(loc GPSLocation, inside bool) bool {
+	d := distance(GPSLocation{Lat: f.Lat, Lon: f.Lon}, loc)
+	if inside {
+		return d > f.Radius+*geofenceBuffer
+	}
+	return d <= f.Radius-*geofenceBuffer
+}
+
+// checkGeofences broadcasts a geofence-enter or geofence-exit event for
+// every fence loc's tracker has crossed. A crossing is only confirmed once
+// -geofence-confirm consecutive fixes are past the buffer on the new side,
+// so jitter on a boundary does not produce a storm of alerts.
+func checkGeofences(loc GPSLocation) {
+	if len(geofences) == 0 {
+		return
+	}
+	var entered, left []geofence
+
+	fenceMutex.Lock()
+	states := insideOf[loc.ID]
+	if states == nil {
+		states = make(map[string]*fenceState)
+		insideOf[loc.ID] = states
+	}
+	for _, f := range geofences {
+		st := states[f.ID]
+		if st == nil {
+			st = &fenceState{}
+			states[f.ID] = st
+		}
+		if !f.crossed(loc, st.inside) {
+			st.pending = 0
+			continue
+		}
+		if st.pending++; st.pending >= *geofenceConfirm {
+			st.inside, st.pending = !st.inside, 0
+			if st.inside {
+				entered = append(entered, f)
+			} else {
+				left = append(left, f)
+			}
+		}
+	}
+	fenceMutex.Unlock()
+
+	for _, f := range entered {
+		broadcastCrossing(loc, f, "geofence-enter", fmt.Sprintf("%s entered %s", loc.ID, f.ID))
+	}
+	for _, f := range left {
+		broadcastCrossing(loc, f, "geofence-exit", fmt.Sprintf("%s left %s", loc.ID, f.ID))
+	}
+}
+
+func broadcastCrossing(loc GPSLocation, f geofence, msgType, logMsg string) {
+	log.Println(logMsg)
+	broadcastMessage(SSEMessage{
+		Type:    msgType,
+		ID:      loc.ID,
+		Lat:     &loc.Lat,
+		Lon:     &loc.Lon,
+		Event:   f.ID,
+		Message: logMsg,
+		Channel: channelGPS,
+		Time:    time.Now(),
+	})
+}
+
+// fencesOf returns the ids of the fences a tracker is confirmed inside, in
+// file order and never nil.
+func fencesOf(id string) []string {
+	ids := []string{}
+	fenceMutex.Lock()
+	defer fenceMutex.Unlock()
+	for _, f := range geofences {
+		if st := insideOf[id][f.ID]; st != nil && st.inside {
+			ids = append(ids, f.ID)
+		}
+	}
+	return ids
+}
+
+// forgetGeofences drops the membership of removed trackers.
+func forgetGeofences(ids []string) {
+	fenceMutex.Lock()
+	for _, id := range ids {
+		delete(insideOf, id)
+	}
+	fenceMutex.Unlock()
+}
+
+// geofenceMembershipHandler returns the fences a tracker is confirmed inside,
+// matching the enter and exit events it has raised.
+func geofenceMembershipHandler(w http.ResponseWriter, r *http.Request) error {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return missingParam("id")
+	}
+	if _, ok := gpsLocations.get(id); !ok {
+		return newAPIError(http.StatusNotFound, codeNotFound, "Unknown tracker: "+id)
+	}
+	return writeJSON(w, r, map[string]any{"id": id, "fences": fencesOf(id)})
+}
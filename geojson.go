@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// geoJSONFeature is a tracker position as a GeoJSON Point feature.
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONPoint      `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // longitude first, per RFC 7946
+}
+
+type geoJSONProperties struct {
+	ID          string    `json:"id"`
+	DisplayName string    `json:"display_name"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Speed       *float64  `json:"speed,omitempty"` // between the last two fixes, in -speed-units
+	Unit        string    `json:"unit,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	Uncertain   bool      `json:"uncertain,omitempty"`
+}
+
+// displayName labels a feature on a map: the tracker id, followed by the
+// reporting source when there is one.
+func displayName(loc GPSLocation) string {
+	if loc.Source == "" {
+		return loc.ID
+	}
+	return loc.ID + " (" + loc.Source + ")"
+}
+
+// lastSpeed returns the speed of id between its last two fixes.
+func lastSpeed(id string) (float64, bool) {
+	gpsMutex.Lock()
+	track := tracks[id]
+	var prev, last GPSLocation
+	if n := len(track); n >= 2 {
+		prev, last = track[n-2], track[n-1]
+	}
+	gpsMutex.Unlock()
+	if last.ID == "" {
+		return 0, false
+	}
+	return speedBetween(prev, last)
+}
+
+// geoJSONHandler returns every tracker position as a GeoJSON
+// FeatureCollection that mapping tools can load directly. Field renaming
+// does not apply, since the format is fixed.
+func geoJSONHandler(w http.ResponseWriter, r *http.Request) error {
+	locs := locationList()
+	features := make([]geoJSONFeature, len(locs))
+	for i, loc := range locs {
+		props := geoJSONProperties{
+			ID:          loc.ID,
+			DisplayName: displayName(loc),
+			UpdatedAt:   loc.UpdatedAt,
+			Source:      loc.Source,
+			Uncertain:   loc.Uncertain,
+		}
+		if speed, ok := lastSpeed(loc.ID); ok {
+			props.Speed, props.Unit = &speed, *speedUnits
+		}
+		features[i] = geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   geoJSONPoint{Type: "Point", Coordinates: [2]float64{loc.Lon, loc.Lat}},
+			Properties: props,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	enc := json.NewEncoder(w)
+	if pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); pretty {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(map[string]any{"type": "FeatureCollection", "features": features}); err != nil {
+		return fmt.Errorf("encode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGeoJSONDisplayName(t *testing.T) {
+	resetState(t)
+	for _, loc := range []GPSLocation{
+		{ID: "bus-1", Lat: 1, Lon: 2, UpdatedAt: time.Now()},
+		{ID: "bus-2", Lat: 3, Lon: 4, Source: "gateway", UpdatedAt: time.Now()},
+	} {
+		if err := recordGPS(context.Background(), loc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := serve(apiHandler(geoJSONHandler), http.MethodGet, "/gps.geojson", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("/gps.geojson: %d %s", w.Code, w.Body)
+	}
+	var got struct {
+		Features []geoJSONFeature `json:"features"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]string)
+	for _, f := range got.Features {
+		names[f.Properties.ID] = f.Properties.DisplayName
+	}
+	want := map[string]string{"bus-1": "bus-1", "bus-2": "bus-2 (gateway)"}
+	for id, name := range want {
+		if names[id] != name {
+			t.Errorf("display_name of %s = %q, want %q", id, names[id], name)
+		}
+	}
+}
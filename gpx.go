@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxGPXBytes caps the size of an imported GPX file.
+const maxGPXBytes = 10 << 20
+
+// gpxFile is the subset of GPX 1.1 needed to read recorded routes.
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+type gpxPoint struct {
+	Lat  float64   `xml:"lat,attr"`
+	Lon  float64   `xml:"lon,attr"`
+	Time time.Time `xml:"time"`
+}
+
+// points returns every track and route point in file order.
+func (g *gpxFile) points() []gpxPoint {
+	var pts []gpxPoint
+	for _, trk := range g.Tracks {
+		for _, seg := range trk.Segments {
+			pts = append(pts, seg.Points...)
+		}
+	}
+	for _, rte := range g.Routes {
+		pts = append(pts, rte.Points...)
+	}
+	return pts
+}
+
+// importGPXHandler seeds a tracker's track from a GPX file in the body. The
+// points keep their recorded times in the track, but the last one becomes
+// the current position through recordGPS, stamped now, so -ttl does not
+// expire the tracker at once. With
+// ?replay=true the points are instead fed through the normal GPS path in the
+// background, spaced as recorded and sped up by ?speed=, so dashboards can
+// be developed against a realistic moving tracker.
+func importGPXHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return newAPIError(http.StatusMethodNotAllowed, codeBadMethod, "Use POST")
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return missingParam("id")
+	}
+	if err := checkTrackerID(id); err != nil {
+		return err
+	}
+	var replay bool
+	if s := r.URL.Query().Get("replay"); s != "" {
+		var err error
+		if replay, err = strconv.ParseBool(s); err != nil {
+			return invalidParam("replay")
+		}
+	}
+	speed := 1.0
+	if s := r.URL.Query().Get("speed"); s != "" {
+		var err error
+		if speed, err = strconv.ParseFloat(s, 64); err != nil || speed <= 0 {
+			return invalidParam("speed")
+		}
+	}
+
+	var file gpxFile
+	if err := xml.NewDecoder(http.MaxBytesReader(w, r.Body, maxGPXBytes)).Decode(&file); err != nil {
+		return newAPIError(http.StatusBadRequest, codeInvalidParam, "Malformed GPX: "+err.Error())
+	}
+	pts := file.points()
+	if len(pts) == 0 {
+		return newAPIError(http.StatusBadRequest, codeInvalidParam, "GPX contains no track points")
+	}
+	for i, p := range pts {
+		switch {
+		case p.Time.IsZero():
+			return newAPIError(http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("Track point %d has no time", i+1))
+		case !validCoordinate(p.Lat, 90) || !validCoordinate(p.Lon, 180):
+			return newAPIError(http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("Track point %d is out of range", i+1))
+		}
+	}
+
+	if replay {
+		background.Go(func(ctx context.Context) { replayGPX(ctx, id, pts, speed) })
+		log.Printf("Replaying %d GPX point(s) for %s at %gx", len(pts), id, speed)
+		fmt.Fprintf(w, "Replaying %d point(s) for %s\n", len(pts), id)
+		return nil
+	}
+
+	gpsMutex.Lock()
+	delete(tracks, id)
+	for _, p := range pts[:len(pts)-1] {
+		appendTrack(GPSLocation{ID: id, Lat: p.Lat, Lon: p.Lon, UpdatedAt: p.Time})
+	}
+	gpsMutex.Unlock()
+	last := pts[len(pts)-1]
+	if err := recordGPS(r.Context(), GPSLocation{ID: id, Lat: last.Lat, Lon: last.Lon, UpdatedAt: time.Now()}); err != nil {
+		return err
+	}
+
+	log.Printf("Imported %d GPX point(s) for %s", len(pts), id)
+	fmt.Fprintf(w, "Imported %d point(s) for %s\n", len(pts), id)
+	return nil
+}
+
+// replayGPX records pts as live fixes of id, keeping their recorded spacing
+// divided by speed, until it runs out of points or ctx is cancelled.
+func replayGPX(ctx context.Context, id string, pts []gpxPoint, speed float64) {
+	for i, p := range pts {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(float64(p.Time.Sub(pts[i-1].Time)) / speed)):
+			}
+		}
+		if err := recordGPS(ctx, GPSLocation{ID: id, Lat: p.Lat, Lon: p.Lon, UpdatedAt: time.Now()}); err != nil {
+			warnf("GPX replay for %s stopped: %v", id, err)
+			return
+		}
+	}
+	log.Printf("GPX replay for %s finished", id)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testGPX = `<gpx><trk><trkseg>
+<trkpt lat="52.50" lon="13.40"><time>2020-01-01T10:00:00Z</time></trkpt>
+<trkpt lat="52.51" lon="13.41"><time>2020-01-01T10:01:00Z</time></trkpt>
+<trkpt lat="52.52" lon="13.42"><time>2020-01-01T10:02:00Z</time></trkpt>
+</trkseg></trk></gpx>`
+
+func importGPX(query string) (int, string) {
+	w := serve(apiHandler(importGPXHandler), http.MethodPost, "/import/gpx?"+query, strings.NewReader(testGPX))
+	return w.Code, w.Body.String()
+}
+
+// An imported tracker must survive the TTL sweep even though its points
+// were recorded long ago.
+func TestImportGPXIsNotExpired(t *testing.T) {
+	resetState(t)
+	setFlag(t, defaultTTL, time.Hour)
+
+	if code, body := importGPX("id=rec"); code != http.StatusOK {
+		t.Fatalf("import: %d %s", code, body)
+	}
+	loc, ok := gpsLocations.get("rec")
+	if !ok || loc.Lat != 52.52 || loc.Lon != 13.42 {
+		t.Fatalf("position %+v, want the last point", loc)
+	}
+	if expired(loc.UpdatedAt, loc.TTL, time.Now()) {
+		t.Errorf("imported position stamped %s expires at once", loc.UpdatedAt)
+	}
+	gpsMutex.Lock()
+	track := append([]GPSLocation(nil), tracks["rec"]...)
+	gpsMutex.Unlock()
+	if len(track) != 3 || !track[0].UpdatedAt.Equal(time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("track %+v, want the 3 points with their recorded times", track)
+	}
+}
+
+func TestImportGPXParams(t *testing.T) {
+	resetState(t)
+	if code, _ := importGPX("id=rec&replay=yes-please"); code != http.StatusBadRequest {
+		t.Errorf("replay=yes-please: %d, want 400", code)
+	}
+
+	setFlag(t, idNamespaces, "separate")
+	setFlag(t, trackerIDPrefix, "trk-")
+	if code, _ := importGPX("id=rec"); code != http.StatusBadRequest {
+		t.Errorf("id without the tracker prefix: %d, want 400", code)
+	}
+	if code, body := importGPX("id=trk-rec&replay=0"); code != http.StatusOK {
+		t.Errorf("id=trk-rec&replay=0: %d %s", code, body)
+	}
+}
+
+// encoding/xml parses lat="NaN", which must not reach the store.
+func TestImportGPXRejectsNaN(t *testing.T) {
+	resetState(t)
+	for _, pt := range []string{`lat="NaN" lon="1"`, `lat="1" lon="NaN"`, `lat="+Inf" lon="1"`, `lat="91" lon="1"`} {
+		gpx := `<gpx><trk><trkseg><trkpt ` + pt + `><time>2020-01-01T10:00:00Z</time></trkpt></trkseg></trk></gpx>`
+		w := serve(apiHandler(importGPXHandler), http.MethodPost, "/import/gpx?id=rec", strings.NewReader(gpx))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", pt, w.Code)
+		}
+	}
+	if gpsLocations.len() != 0 {
+		t.Errorf("stored %d locations from rejected points", gpsLocations.len())
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+var (
+	responseHeaders     = flag.String("response-headers", "", "comma-separated Name: value headers added to every response, e.g. 'X-Served-By: edge-1, Cache-Control: no-store'")
+	responseHeadersFile = flag.String("response-headers-file", "", "file of Name: value headers added to every response, one per line; use it for values that contain commas")
+	securityHeaders     = flag.Bool("security-headers", false, "add recommended security headers (HSTS, X-Content-Type-Options, X-Frame-Options, Referrer-Policy) to every response")
+	csp                 = flag.String("csp", defaultCSP, "Content-Security-Policy of the dashboard pages (/, /m and /view); empty disables")
+)
+
+// defaultCSP confines the dashboard pages to their own origin. The embedded
+// pages use inline scripts, styles and onclick handlers, which nonces
+// cannot cover, so those need 'unsafe-inline'; their fetch and EventSource
+// calls are same-origin and pass connect-src 'self'.
+const defaultCSP = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; " +
+	"connect-src 'self'; img-src 'self' data:; object-src 'none'; base-uri 'none'; frame-ancestors 'none'"
+
+// withCSP sets -csp on the responses of an HTML page handler.
+func withCSP(next http.Handler) http.Handler {
+	if *csp == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", *csp)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recommendedHeaders are the defaults -security-headers turns on. Browsers
+// ignore HSTS over plain HTTP, so it only takes effect behind a TLS proxy.
+var recommendedHeaders = [][2]string{
+	{"Strict-Transport-Security", "max-age=31536000; includeSubDomains"},
+	{"X-Content-Type-Options", "nosniff"},
+	{"X-Frame-Options", "DENY"},
+	{"Referrer-Policy", "no-referrer"},
+}
+
+// extraHeaders holds the headers added by withResponseHeaders, built by
+// loadResponseHeaders at startup.
+var extraHeaders = http.Header{}
+
+// loadResponseHeaders builds extraHeaders from -security-headers,
+// -response-headers-file and -response-headers, later sources overriding
+// earlier ones so an operator can adjust a single recommended default.
+func loadResponseHeaders() error {
+	h := http.Header{}
+	if *securityHeaders {
+		for _, kv := range recommendedHeaders {
+			h.Set(kv[0], kv[1])
+		}
+	}
+	if *responseHeadersFile != "" {
+		data, err := os.ReadFile(*responseHeadersFile)
+		if err != nil {
+			return err
+		}
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for n := 1; sc.Scan(); n++ {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err := addHeader(h, line); err != nil {
+				return fmt.Errorf("%s:%d: %w", *responseHeadersFile, n, err)
+			}
+		}
+	}
+	for _, entry := range strings.Split(*responseHeaders, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if err := addHeader(h, entry); err != nil {
+			return fmt.Errorf("invalid -response-headers entry: %w", err)
+		}
+	}
+	extraHeaders = h
+	return nil
+}
+
+// addHeader parses a "Name: value" line into h.
+func addHeader(h http.Header, line string) error {
+	name, value, ok := strings.Cut(line, ":")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("%q: want Name: value", line)
+	}
+	h.Set(textproto.CanonicalMIMEHeaderKey(name), value)
+	return nil
+}
+
+// withResponseHeaders adds extraHeaders to every response. They are set
+// before the handler runs, so anything a handler sets itself wins; in
+// particular /events keeps its own Content-Type and Cache-Control.
+func withResponseHeaders(next http.Handler) http.Handler {
+	if len(extraHeaders) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range extraHeaders {
+			w.Header()[name] = values
+		}
+		next.ServeHTTP(w, r)
+	})
+}
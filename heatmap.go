@@ -0,0 +1,81 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Bounds of /gps/heatmap?precision=, in decimal places of a degree: 0 is a
+// cell of about 111km, 6 about 11cm.
+const (
+	minHeatmapPrecision     = 0
+	maxHeatmapPrecision     = 6
+	defaultHeatmapPrecision = 3
+)
+
+// heatmapCell is the number of positions that fall in one grid cell, keyed
+// by the cell's rounded coordinates.
+type heatmapCell struct {
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+	Count int     `json:"count"`
+}
+
+// heatmapHandler buckets tracker positions into a grid of ?precision=
+// decimal places. By default it counts each tracker's current position;
+// with ?window= it counts every fix in the recorded tracks that is newer
+// than the window. Cells are returned busiest first.
+func heatmapHandler(w http.ResponseWriter, r *http.Request) error {
+	precision := defaultHeatmapPrecision
+	if s := r.URL.Query().Get("precision"); s != "" {
+		p, err := strconv.Atoi(s)
+		if err != nil {
+			return invalidParam("precision")
+		}
+		precision = min(max(p, minHeatmapPrecision), maxHeatmapPrecision)
+	}
+	var window time.Duration
+	if s := r.URL.Query().Get("window"); s != "" {
+		var err error
+		if window, err = time.ParseDuration(s); err != nil || window <= 0 {
+			return invalidParam("window")
+		}
+	}
+
+	scale := math.Pow10(precision)
+	counts := make(map[[2]float64]int)
+	// Adding zero turns the -0 of small negative coordinates into 0.
+	round := func(coord float64) float64 { return math.Round(coord*scale)/scale + 0 }
+	add := func(loc GPSLocation) {
+		counts[[2]float64{round(loc.Lat), round(loc.Lon)}]++
+	}
+	if window == 0 {
+		for _, loc := range gpsLocations.snapshot() {
+			add(loc)
+		}
+	} else {
+		cutoff := time.Now().Add(-window)
+		gpsMutex.Lock()
+		for _, track := range tracks {
+			for _, loc := range track {
+				if !loc.UpdatedAt.Before(cutoff) {
+					add(loc)
+				}
+			}
+		}
+		gpsMutex.Unlock()
+	}
+
+	cells := make([]heatmapCell, 0, len(counts))
+	for key, n := range counts {
+		cells = append(cells, heatmapCell{Lat: key[0], Lon: key[1], Count: n})
+	}
+	slices.SortFunc(cells, func(a, b heatmapCell) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Lat, b.Lat), cmp.Compare(a.Lon, b.Lon))
+	})
+	return writeJSON(w, r, cells)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// Hysteresis thresholds. A reported value that differs from a device's
+// official value only becomes official once it has been reported the given
+// number of times in a row and has held for the given duration. Rising
+// (false to true) and falling (true to false) transitions are configured
+// separately, so a chattering presence sensor can be made quick to arrive
+// and slow to leave.
+var (
+	riseCount = flag.Int("rise-count", 1, "consecutive true reports needed before a device becomes present")
+	riseHold  = flag.Duration("rise-hold", 0, "how long true must be reported before a device becomes present")
+	fallCount = flag.Int("fall-count", 1, "consecutive false reports needed before a device stops being present")
+	fallHold  = flag.Duration("fall-hold", 0, "how long false must be reported before a device stops being present")
+)
+
+// pendingChange is a candidate value a device has reported but that has not
+// yet met its threshold.
+type pendingChange struct {
+	value bool
+	since time.Time
+	count int
+}
+
+// pendingChanges holds the candidate value of each device in transition. It
+// is guarded by mutex, which also serializes writes to devices.
+var pendingChanges = make(map[string]pendingChange)
+
+// heldError is returned when a reported value is held back by hysteresis.
+type heldError struct {
+	ID    string
+	Value bool
+	Count int
+}
+
+func (e *heldError) Error() string {
+	return fmt.Sprintf("change of %s to %v pending (%d report(s) so far)", e.ID, e.Value, e.Count)
+}
+
+// settle reports whether dev may replace the official state prev. Reports
+// matching the official value cancel any transition in progress. The caller
+// must hold mutex.
+func settle(dev, prev DeviceState) error {
+	if dev.Value == prev.Value {
+		delete(pendingChanges, dev.ID)
+		return nil
+	}
+	count, hold := *riseCount, *riseHold
+	if !dev.Value {
+		count, hold = *fallCount, *fallHold
+	}
+	p, ok := pendingChanges[dev.ID]
+	if !ok || p.value != dev.Value {
+		p = pendingChange{value: dev.Value, since: dev.UpdatedAt}
+	}
+	p.count++
+	if p.count >= count && dev.UpdatedAt.Sub(p.since) >= hold {
+		delete(pendingChanges, dev.ID)
+		return nil
+	}
+	pendingChanges[dev.ID] = p
+	return &heldError{ID: dev.ID, Value: dev.Value, Count: p.count}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// A chattering sensor's reports only change the official value once they
+// meet the count and hold of their direction.
+func TestHysteresisChatter(t *testing.T) {
+	type report struct {
+		at    time.Duration // after the first report
+		value bool
+		want  bool // official value afterwards
+	}
+	for _, tc := range []struct {
+		name                 string
+		riseCount, fallCount int
+		riseHold, fallHold   time.Duration
+		start                bool
+		reports              []report
+	}{
+		{
+			name: "rise count resets on chatter", riseCount: 3, fallCount: 1,
+			reports: []report{
+				{1 * time.Second, true, false},
+				{2 * time.Second, false, false},
+				{3 * time.Second, true, false},
+				{4 * time.Second, true, false},
+				{5 * time.Second, false, false},
+				{6 * time.Second, true, false},
+				{7 * time.Second, true, false},
+				{8 * time.Second, true, true},
+			},
+		},
+		{
+			name: "fall hold restarts after a true", riseCount: 1, fallCount: 1, fallHold: 30 * time.Second, start: true,
+			reports: []report{
+				{0, false, true},
+				{10 * time.Second, false, true},
+				{20 * time.Second, true, true},
+				{25 * time.Second, false, true},
+				{40 * time.Second, false, true},
+				{50 * time.Second, false, true},
+				{55 * time.Second, false, false},
+			},
+		},
+		{
+			name: "quick to arrive, slow to leave", riseCount: 1, fallCount: 3, start: false,
+			reports: []report{
+				{1 * time.Second, true, true},
+				{2 * time.Second, false, true},
+				{3 * time.Second, false, true},
+				{4 * time.Second, true, true},
+				{5 * time.Second, false, true},
+				{6 * time.Second, false, true},
+				{7 * time.Second, false, false},
+				{8 * time.Second, true, true},
+			},
+		},
+		{
+			name: "count and hold must both be met", riseCount: 2, riseHold: 10 * time.Second, fallCount: 1,
+			reports: []report{
+				{0, true, false},
+				{1 * time.Second, true, false},
+				{5 * time.Second, true, false},
+				{10 * time.Second, true, true},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetState(t)
+			setFlag(t, riseCount, tc.riseCount)
+			setFlag(t, fallCount, tc.fallCount)
+			setFlag(t, riseHold, tc.riseHold)
+			setFlag(t, fallHold, tc.fallHold)
+
+			t0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+			if err := recordDevice(context.Background(), DeviceState{ID: "d", Value: tc.start, UpdatedAt: t0.Add(-time.Second)}); err != nil {
+				t.Fatal(err)
+			}
+			for i, r := range tc.reports {
+				err := recordDevice(context.Background(), DeviceState{ID: "d", Value: r.value, UpdatedAt: t0.Add(r.at)})
+				var held *heldError
+				if err != nil && !errors.As(err, &held) {
+					t.Fatalf("report %d: %v", i, err)
+				}
+				if dev, _ := devices.get("d"); dev.Value != r.want {
+					t.Fatalf("report %d (%v at +%s): official value %v, want %v", i, r.value, r.at, dev.Value, r.want)
+				}
+				if wantHeld := r.value != r.want; (held != nil) != wantHeld {
+					t.Errorf("report %d (%v at +%s): held = %v, want %v", i, r.value, r.at, held != nil, wantHeld)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// parseIDs parses the ?ids= param of /events into a set, nil when absent.
+func parseIDs(s string) (map[string]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ids := make(map[string]bool)
+	for _, id := range strings.Split(s, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			return nil, invalidParam("ids")
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// snapshotEvents describes the current state of each of ids as the update
+// and gps events that produced it, oldest first, so an ids subscriber starts
+// from the same picture as a client that had been connected all along.
+func snapshotEvents(ids map[string]bool) []SSEMessage {
+	devs := devices.snapshot()
+	locs := gpsLocations.snapshot()
+
+	var events []SSEMessage
+	for id := range ids {
+		if dev, ok := devs[id]; ok {
+			msg := fmt.Sprintf("Attendance unregistered for %s", id)
+			if dev.Value {
+				msg = fmt.Sprintf("Attendance registered for %s", id)
+			}
+			events = append(events, SSEMessage{
+				Type:    "update",
+				ID:      id,
+				Message: msg,
+				Channel: channelAttendance,
+				Time:    dev.UpdatedAt,
+			})
+		}
+		if loc, ok := locs[id]; ok {
+			events = append(events, SSEMessage{
+				Type:    "gps",
+				ID:      id,
+				Lat:     &loc.Lat,
+				Lon:     &loc.Lon,
+				Source:  loc.Source,
+				Message: fmt.Sprintf("Location update received for %s %.6f, %.6f", id, loc.Lat, loc.Lon),
+				Channel: channelGPS,
+				Time:    loc.UpdatedAt,
+			})
+		}
+	}
+	slices.SortFunc(events, func(a, b SSEMessage) int { return a.Time.Compare(b.Time) })
+	return events
+}
+
+// writeSnapshot sends c the snapshot of its ids, encoded as the broker would
+// encode live events for it.
+func writeSnapshot(w http.ResponseWriter, rc *http.ResponseController, c *client) error {
+	return writeEvents(w, rc, c, snapshotEvents(c.ids))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// journalHandler returns the timeline of one device or tracker: every
+// buffered event about it, of any type, oldest first. ?from= and ?to=
+// bound the event times, ?types= keeps only the listed types, and ?since=
+// continues a truncated page.
+func journalHandler(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	id := q.Get("id")
+	if id == "" {
+		return missingParam("id")
+	}
+
+	var from, to time.Time
+	if f := q.Get("from"); f != "" {
+		var err error
+		if from, err = time.Parse(time.RFC3339Nano, f); err != nil {
+			return invalidParam("from")
+		}
+	}
+	if t := q.Get("to"); t != "" {
+		var err error
+		if to, err = time.Parse(time.RFC3339Nano, t); err != nil {
+			return invalidParam("to")
+		}
+	}
+	if !to.IsZero() && from.After(to) {
+		return newAPIError(http.StatusBadRequest, codeInvalidParam, "from must not be after to")
+	}
+
+	var types map[string]bool
+	if t := q.Get("types"); t != "" {
+		types = make(map[string]bool)
+		for _, name := range strings.Split(t, ",") {
+			types[strings.TrimSpace(name)] = true
+		}
+	}
+
+	var since uint64
+	if s := q.Get("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return invalidParam("since")
+		}
+	}
+	limit, err := queryLimit(r)
+	if err != nil {
+		return err
+	}
+
+	// The history buffer holds at most 1000 events, so a linear scan stays cheap
+	// and needs no per-id index to keep in sync.
+	events := []SSEMessage{}
+	historyMutex.Lock()
+	for _, msg := range history {
+		if msg.ID != id || msg.Seq <= since || msg.Time.Before(from) || (!to.IsZero() && msg.Time.After(to)) {
+			continue
+		}
+		if types != nil && !types[msg.Type] {
+			continue
+		}
+		if len(events) == limit {
+			markTruncated(w, strconv.FormatUint(events[limit-1].Seq, 10))
+			break
+		}
+		events = append(events, msg)
+	}
+	historyMutex.Unlock()
+
+	return writeJSON(w, r, events)
+}
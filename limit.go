@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+)
+
+const codeOverloaded = "overloaded"
+
+var maxInFlight = flag.Int("max-inflight", 0, "maximum concurrent non-streaming requests before answering 503 (0 is unlimited)")
+
+// limitConcurrency caps how many requests run h at once. Excess requests
+// are refused immediately rather than queued, so a burst cannot pile up
+// goroutines on a small host.
+func limitConcurrency(sem chan struct{}, next http.Handler) http.Handler {
+	if sem == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", "1")
+			writeError(w, newAPIError(http.StatusServiceUnavailable, codeOverloaded, "Server busy, retry shortly"))
+		}
+	})
+}
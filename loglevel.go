@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+var (
+	logLevelFile   = flag.String("log-level-file", "debug", "lowest level written to the log file: debug, info, warn or error")
+	logLevelStdout = flag.String("log-level-stdout", "debug", "lowest level written to stdout: debug, info, warn or error")
+)
+
+// Log levels. Lines tagged with levelTags[l] after the timestamp have level
+// l; untagged lines are info.
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+var levelTags = [][]byte{[]byte("[DEBUG] "), nil, []byte("[WARN] "), []byte("[ERROR] ")}
+
+func parseLevel(name string) (int, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level %q: want debug, info, warn or error", name)
+}
+
+func debugf(format string, args ...any) { log.Printf("[DEBUG] "+format, args...) }
+func warnf(format string, args ...any)  { log.Printf("[WARN] "+format, args...) }
+func errorf(format string, args ...any) { log.Printf("[ERROR] "+format, args...) }
+
+// lineLevel finds the level tag in the header of a log line.
+func lineLevel(line []byte) int {
+	head := line[:min(len(line), 64)] // room for long -log-time-format stamps
+	for l, tag := range levelTags {
+		if tag != nil && bytes.Contains(head, tag) {
+			return l
+		}
+	}
+	return levelInfo
+}
+
+// levelWriter passes on log lines at or above min. The log package hands
+// each entry to Write in one call, so every call is one line.
+type levelWriter struct {
+	w   io.Writer
+	min int
+}
+
+func (lw levelWriter) Write(p []byte) (int, error) {
+	if lineLevel(p) < lw.min {
+		return len(p), nil
+	}
+	return lw.w.Write(p)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// logPath is the file the server currently logs to.
+var logPath atomic.Value // string
+
+func currentLogPath() string {
+	p, _ := logPath.Load().(string)
+	return p
+}
+
+const logPollInterval = 500 * time.Millisecond
+
+// logTail follows a log file by path. It starts at the end of the file,
+// reopens it when the path is switched to a new file and rewinds when the
+// file is truncated.
+type logTail struct {
+	file   *os.File
+	reader *bufio.Reader
+	offset int64
+	path   string
+}
+
+func (t *logTail) open(path string, atEnd bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	var offset int64
+	if atEnd {
+		if offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if t.file != nil {
+		t.file.Close()
+	}
+	t.file, t.reader, t.offset, t.path = f, bufio.NewReader(f), offset, path
+	return nil
+}
+
+func (t *logTail) Close() {
+	if t.file != nil {
+		t.file.Close()
+	}
+}
+
+// check reopens or rewinds the file if it was rotated or truncated.
+func (t *logTail) check() error {
+	path := currentLogPath()
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil // mid-rotation; try again next poll
+	}
+	cur, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+	if path != t.path || !os.SameFile(info, cur) {
+		return t.open(path, false)
+	}
+	if info.Size() < t.offset {
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		t.reader.Reset(t.file)
+		t.offset = 0
+	}
+	return nil
+}
+
+// lines returns the complete lines appended since the last call. A trailing
+// partial line is left for the next call.
+func (t *logTail) lines() ([]string, error) {
+	var out []string
+	for {
+		line, err := t.reader.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// Unread the partial line so it is returned whole later.
+				if len(line) > 0 {
+					if _, err := t.file.Seek(t.offset, io.SeekStart); err != nil {
+						return out, err
+					}
+					t.reader.Reset(t.file)
+				}
+				return out, nil
+			}
+			return out, err
+		}
+		t.offset += int64(len(line))
+		out = append(out, strings.TrimRight(line, "\r\n"))
+	}
+}
+
+// logStreamHandler pushes new lines of the server log as SSE events.
+func logStreamHandler(w http.ResponseWriter, r *http.Request) error {
+	path := currentLogPath()
+	if path == "" {
+		return newAPIError(http.StatusNotFound, codeNotFound, "No log file")
+	}
+
+	tail := &logTail{}
+	if err := tail.open(path, true); err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	defer tail.Close()
+
+	setSSEHeaders(w)
+	rc := http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	writePadding(w)
+	rc.Flush()
+
+	ticker := time.NewTicker(logPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-ticker.C:
+			if err := tail.check(); err != nil {
+				return nil
+			}
+			lines, err := tail.lines()
+			for _, line := range lines {
+				data, _ := json.Marshal(SSEMessage{Type: "log", Message: line, Time: time.Now()})
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+					return nil
+				}
+			}
+			if len(lines) > 0 {
+				if err := rc.Flush(); err != nil {
+					return nil
+				}
+			}
+			if err != nil {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+)
+
+var logTimeFormat = flag.String("log-time-format", "", "timestamp of log lines: rfc3339, unix, unixnano or a Go reference layout such as 2006-01-02T15:04:05.000Z07:00 (default: the log package's 2006/01/02 15:04:05)")
+
+// namedTimeFormats are the -log-time-format names that map to a fixed
+// layout; unix and unixnano are numeric and handled by stampFunc.
+var namedTimeFormats = map[string]string{
+	"rfc3339": time.RFC3339,
+}
+
+// stampFunc returns the formatter for -log-time-format, or nil when the log
+// package's own timestamp is kept.
+func stampFunc(format string) (func(time.Time) []byte, error) {
+	switch format {
+	case "":
+		return nil, nil
+	case "unix":
+		return func(t time.Time) []byte { return strconv.AppendInt(nil, t.Unix(), 10) }, nil
+	case "unixnano":
+		return func(t time.Time) []byte { return strconv.AppendInt(nil, t.UnixNano(), 10) }, nil
+	}
+	layout, ok := namedTimeFormats[format]
+	if !ok {
+		// A string without any reference fields formats to itself.
+		if time.Unix(0, 0).Format(format) == format {
+			return nil, fmt.Errorf("invalid -log-time-format %q: want rfc3339, unix, unixnano or a Go layout", format)
+		}
+		layout = format
+	}
+	return func(t time.Time) []byte { return t.AppendFormat(nil, layout) }, nil
+}
+
+// stampWriter prefixes each log line with its timestamp. The log package
+// calls Write once per line, under its own lock.
+type stampWriter struct {
+	w     io.Writer
+	stamp func(time.Time) []byte
+}
+
+func (sw stampWriter) Write(p []byte) (int, error) {
+	line := append(sw.stamp(time.Now()), ' ')
+	if _, err := sw.w.Write(append(line, p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// setLogOutput directs the log package to w, stamping lines as configured
+// by -log-time-format.
+func setLogOutput(w io.Writer) error {
+	stamp, err := stampFunc(*logTimeFormat)
+	if err != nil {
+		return err
+	}
+	if stamp == nil {
+		log.SetOutput(w)
+		log.SetFlags(log.LstdFlags)
+		return nil
+	}
+	log.SetOutput(stampWriter{w, stamp})
+	log.SetFlags(0)
+	return nil
+}
@@ -1,37 +1,123 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	_ "embed"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 //go:embed index.html
 var indexHTML []byte
 
+//go:embed mobile.html
+var mobileHTML []byte
+
 type DeviceState struct {
-	ID    string
-	Value bool
+	ID        string        `json:"id"`
+	Value     bool          `json:"value"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Reason    string        `json:"reason,omitempty"` // why the value was last set, e.g. manual
+	Status    string        `json:"status,omitempty"` // derived by deviceStatus in read responses, never stored
+	TTL       time.Duration `json:"-"`                // overrides -ttl when non-zero
 }
 
 type GPSLocation struct {
-	ID  string
-	Lat float64
-	Lon float64
+	ID        string        `json:"id"`
+	Lat       float64       `json:"lat"`
+	Lon       float64       `json:"lon"`
+	Source    string        `json:"source,omitempty"` // reporter of the fix when several report one id
+	UpdatedAt time.Time     `json:"updated_at"`
+	Uncertain bool          `json:"uncertain,omitempty"` // last fix before a reporting gap
+	TTL       time.Duration `json:"-"`                   // overrides -ttl when non-zero
+}
+
+var (
+	staleUpdates  = flag.String("stale-updates", "reject", "how to handle a ?ts= older than the stored state: reject (409) or ignore")
+	maxUpdateAge  = flag.Duration("max-update-age", 0, "reject a ?ts= further than this in the past with 400 (0 accepts any)")
+	maxFutureSkew = flag.Duration("max-future-skew", 0, "reject a ?ts= further than this in the future with 400 (0 accepts any)")
+)
+
+// parseUpdateTime parses a client timestamp, or returns the server time for
+// an empty one. A ts outside -max-update-age and -max-future-skew is
+// rejected, since it comes from a device with a broken clock.
+func parseUpdateTime(ts string) (time.Time, error) {
+	if ts == "" {
+		return now(), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, invalidParam("ts")
+	}
+	if age := now().Sub(t); *maxUpdateAge > 0 && age > *maxUpdateAge {
+		return time.Time{}, newAPIError(http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("ts is %s old, more than -max-update-age allows", age.Round(time.Second)))
+	} else if *maxFutureSkew > 0 && -age > *maxFutureSkew {
+		return time.Time{}, newAPIError(http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("ts is %s in the future, more than -max-future-skew allows", (-age).Round(time.Second)))
+	}
+	return t, nil
+}
+
+// staleError is returned when an update is older than the stored state.
+type staleError struct {
+	ID     string
+	Stored time.Time
+}
+
+func (e *staleError) Error() string {
+	return fmt.Sprintf("update for %s is older than stored state (%s)", e.ID, e.Stored.Format(time.RFC3339Nano))
+}
+
+// staleUpdate answers a write whose timestamp is older than the stored state,
+// so that a retried request cannot clobber fresher data.
+func staleUpdate(w http.ResponseWriter, stale *staleError) error {
+	if *staleUpdates == "ignore" {
+		fmt.Fprintf(w, "Ignored stale update for %s\n", stale.ID)
+		return nil
+	}
+	return newAPIError(http.StatusConflict, codeStaleUpdate,
+		fmt.Sprintf("Update for %s is older than stored state (%s)", stale.ID, stale.Stored.Format(time.RFC3339Nano)))
 }
 
 // SSE Event Structure
 type SSEMessage struct {
-	Type    string `json:"type"`
-	Message string `json:"message"`
+	Seq     uint64         `json:"seq,omitempty"` // assigned when stored in history
+	Type    string         `json:"type"`
+	ID      string         `json:"id,omitempty"`  // device or tracker the event concerns
+	Lat     *float64       `json:"lat,omitempty"` // position, for gps events
+	Lon     *float64       `json:"lon,omitempty"`
+	Source  string         `json:"source,omitempty"` // GPS source that supplied the position
+	Trail   []trailPoint   `json:"trail,omitempty"`  // recent fixes, with -broadcast-trail
+	Event   string         `json:"event,omitempty"`  // what happened, for system events
+	Stats   *deliveryStats `json:"stats,omitempty"`  // per-connection counters, for client-stats
+	Speed   *float64       `json:"speed,omitempty"`  // computed speed, for speed-alert events
+	Unit    string         `json:"unit,omitempty"`   // unit of Speed, per -speed-units
+	Origin  *requestOrigin `json:"origin,omitempty"` // who caused the event, with -event-origin
+	State   *fullState     `json:"state,omitempty"`  // every device and location, for snapshot events
+	Level   string         `json:"level,omitempty"`  // info or warn, for note events
+	Reason  string         `json:"reason,omitempty"` // why a device changed or was removed, for update and remove events
+	Message string         `json:"message"`
+	Channel string         `json:"channel,omitempty"`
+	Time    time.Time      `json:"time"`
+
+	span trace.SpanContext // request that caused the event, for tracing
+	sent time.Time         // when it was broadcast, with -delivery-timing
 }
 
 // History
@@ -39,110 +125,204 @@ var (
 	history      []SSEMessage
 	historyMutex sync.Mutex
 	maxHistory   = 1000
+	lastSeq      uint64 // sequence number of the newest stored event
 )
 
-func addToHistory(msg SSEMessage) {
+// addToHistory assigns msg the next sequence number and stores it.
+func addToHistory(msg SSEMessage) SSEMessage {
 	historyMutex.Lock()
 	defer historyMutex.Unlock()
 
+	lastSeq++
+	msg.Seq = lastSeq
 	history = append(history, msg)
-	if len(history) > maxHistory {
-		history = history[1:]
+	if len(history) > historyLimit() {
+		history = history[len(history)-historyLimit():]
 	}
+	return msg
+}
+
+var (
+	gpsLocations cowMap[GPSLocation]
+	gpsMutex     sync.Mutex // serializes writes to gpsLocations
+	devices      cowMap[DeviceState]
+	mutex        sync.Mutex // serializes writes to devices
+	broker       *Broker
+)
+
+func broadcast(msgType, msgContent string) {
+	broadcastFor("", msgType, msgContent)
+}
+
+// broadcastFor is broadcast for events about a single device or tracker.
+func broadcastFor(id, msgType, msgContent string) {
+	broadcastMessage(SSEMessage{
+		Type:    msgType,
+		ID:      id,
+		Message: msgContent,
+		Channel: channelFor(msgType),
+		Time:    time.Now(),
+	})
 }
 
-// Broker manages SSE clients
-type Broker struct {
-	Notifier       chan []byte
-	newClients     chan chan []byte
-	closingClients chan chan []byte
-	clients        map[chan []byte]bool
+// broadcastMessage records msg in history and sends it to connected clients.
+func broadcastMessage(msg SSEMessage) {
+	stampBroadcast(&msg)
+	broadcastMutex.Lock()
+	defer broadcastMutex.Unlock()
+	msg = addToHistory(msg)
+	countEvent(msg)
+	notify(msg)
 }
 
-func NewBroker() *Broker {
-	broker := &Broker{
-		Notifier:       make(chan []byte, 1),
-		newClients:     make(chan chan []byte),
-		closingClients: make(chan chan []byte),
-		clients:        make(map[chan []byte]bool),
+// notify sends msg to connected clients without recording it in history.
+func notify(msg SSEMessage) {
+	stampBroadcast(&msg)
+	if msg.Channel == "" {
+		msg.Channel = channelFor(msg.Type)
 	}
-	go broker.listen()
-	return broker
+	broker.Notifier <- msg
 }
 
-func (broker *Broker) listen() {
-	for {
-		select {
-		case s := <-broker.newClients:
-			broker.clients[s] = true
-			log.Printf("Client added. Total: %d", len(broker.clients))
-		case s := <-broker.closingClients:
-			delete(broker.clients, s)
-			log.Printf("Client removed. Total: %d", len(broker.clients))
-		case event := <-broker.Notifier:
-			for clientMessageChan := range broker.clients {
-				select {
-				case clientMessageChan <- event:
-				default:
-					// Drop message if client is blocked
-				}
-			}
+// tryNotify is notify for advisory events, which are dropped rather than
+// wait when the notifier queue is full.
+func tryNotify(msg SSEMessage) bool {
+	stampBroadcast(&msg)
+	if msg.Channel == "" {
+		msg.Channel = channelFor(msg.Type)
+	}
+	select {
+	case broker.Notifier <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func historyHandler(w http.ResponseWriter, r *http.Request) error {
+	var since uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return invalidParam("since")
 		}
 	}
+	limit, err := queryLimit(r)
+	if err != nil {
+		return err
+	}
+
+	historyMutex.Lock()
+	events := eventsAfter(w, since, lastSeq, limit)
+	historyMutex.Unlock()
+
+	return writeJSON(w, r, events)
 }
 
-func (broker *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+// deviceList returns a snapshot of every device, sorted by id.
+func deviceList() []DeviceState {
+	snap := devices.snapshot()
+	list := make([]DeviceState, 0, len(snap))
+	for _, dev := range snap {
+		dev.Status = deviceStatus(dev)
+		list = append(list, dev)
+	}
 
-	messageChan := make(chan []byte)
-	broker.newClients <- messageChan
+	slices.SortFunc(list, func(a, b DeviceState) int { return cmp.Compare(a.ID, b.ID) })
+	return list
+}
 
-	defer func() {
-		broker.closingClients <- messageChan
-	}()
+// locationList returns a snapshot of every tracker position, sorted by id.
+func locationList() []GPSLocation {
+	snap := gpsLocations.snapshot()
+	list := make([]GPSLocation, 0, len(snap))
+	for _, loc := range snap {
+		list = append(list, loc)
+	}
 
-	notify := r.Context().Done()
+	slices.SortFunc(list, func(a, b GPSLocation) int { return cmp.Compare(a.ID, b.ID) })
+	return list
+}
 
-	for {
-		select {
-		case <-notify:
-			return
-		case msg := <-messageChan:
-			fmt.Fprintf(w, "data: %s\n\n", msg)
-			w.(http.Flusher).Flush()
-		}
+func devicesHandler(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, r, deviceList())
+}
+
+func locationsHandler(w http.ResponseWriter, r *http.Request) error {
+	out, err := formatLocations(r, locationList())
+	if err != nil {
+		return err
 	}
+	return writeJSON(w, r, out)
 }
 
-var (
-	gpsLocations = make(map[string]GPSLocation)
-	gpsMutex     sync.Mutex
-	devices      = make(map[string]bool)
-	mutex        sync.Mutex
-	broker       *Broker
-)
+// dumpHandler returns the buffered events with since < seq <= until as a
+// single JSON array, for consumers that want a window rather than a stream.
+func dumpHandler(w http.ResponseWriter, r *http.Request) error {
+	var since, until uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return invalidParam("since")
+		}
+	}
 
-func broadcast(msgType, msgContent string) {
-	msg := SSEMessage{Type: msgType, Message: msgContent}
-	addToHistory(msg)
-	jsonMsg, _ := json.Marshal(msg)
-	broker.Notifier <- jsonMsg
+	limit, err := queryLimit(r)
+	if err != nil {
+		return err
+	}
+
+	historyMutex.Lock()
+	until = lastSeq
+	if u := r.URL.Query().Get("until"); u != "" && u != "now" {
+		var err error
+		if until, err = strconv.ParseUint(u, 10, 64); err != nil {
+			historyMutex.Unlock()
+			return invalidParam("until")
+		}
+	}
+	events := eventsAfter(w, since, until, limit)
+	historyMutex.Unlock()
+
+	return writeJSON(w, r, events)
 }
 
-func historyHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+// activityHandler counts buffered events of a type within a recent window.
+func activityHandler(w http.ResponseWriter, r *http.Request) error {
+	msgType := r.URL.Query().Get("type")
+	windowStr := r.URL.Query().Get("window")
+	if windowStr == "" {
+		windowStr = "1m"
+	}
+
+	window, err := time.ParseDuration(windowStr)
+	if err != nil || window <= 0 {
+		return invalidParam("window")
+	}
+
+	cutoff := time.Now().Add(-window)
+	count := 0
 
 	historyMutex.Lock()
-	defer historyMutex.Unlock()
+	for i := len(history) - 1; i >= 0; i-- {
+		msg := history[i]
+		if msg.Time.Before(cutoff) {
+			break
+		}
+		if msgType == "" || msg.Type == msgType {
+			count++
+		}
+	}
+	historyMutex.Unlock()
 
-	json.NewEncoder(w).Encode(history)
+	return writeJSON(w, r, map[string]any{
+		"type":   msgType,
+		"window": window.String(),
+		"count":  count,
+	})
 }
 
-func clearHandler(w http.ResponseWriter, r *http.Request) {
+func clearHandler(w http.ResponseWriter, r *http.Request) error {
 	historyMutex.Lock()
 	history = []SSEMessage{}
 	historyMutex.Unlock()
@@ -152,77 +332,192 @@ func clearHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Cleared"))
+	return nil
 }
 
-func gpsHandler(w http.ResponseWriter, r *http.Request) {
+func gpsHandler(w http.ResponseWriter, r *http.Request) error {
 	// log.Printf("Received GPS request: %v", r.URL.Query())
-	id := r.URL.Query().Get("id")
-	latStr := r.URL.Query().Get("lat")
-	lonStr := r.URL.Query().Get("lon")
-
-	if id == "" {
-		http.Error(w, "Missing id param", http.StatusBadRequest)
-		return
+	p, err := validate(r.URL.Query(), gpsValidators)
+	if err != nil {
+		return err
 	}
 
-	lat, err := strconv.ParseFloat(latStr, 64)
-	if err != nil {
-		http.Error(w, "Invalid lat param", http.StatusBadRequest)
-		return
+	if err := recordGPS(r.Context(), p.location()); err != nil {
+		var stale *staleError
+		if errors.As(err, &stale) {
+			return staleUpdate(w, stale)
+		}
+		return err
 	}
 
-	lon, err := strconv.ParseFloat(lonStr, 64)
-	if err != nil {
-		http.Error(w, "Invalid lon param", http.StatusBadRequest)
-		return
+	writeDone(w, "GPS updated for %s: %.6f, %.6f\n", p.ID, p.Lat, p.Lon)
+	return nil
+}
+
+// recordGPS stores a fix and broadcasts it. It is shared by every GPS
+// ingestion path and returns a *staleError if the fix is older than the
+// stored one. A zero TTL keeps the tracker's previous override. The
+// broadcast is traced as part of the span in ctx.
+func recordGPS(ctx context.Context, loc GPSLocation) error {
+	id := loc.ID
+	if err := checkTrackerID(id); err != nil {
+		return err
 	}
+	defer lockID(id)()
 
 	gpsMutex.Lock()
-	gpsLocations[id] = GPSLocation{ID: id, Lat: lat, Lon: lon}
-	gpsMutex.Unlock()
-
-	logMsg := fmt.Sprintf("Location update received for %s %.6f, %.6f", id, lat, lon)
-	log.Println(logMsg)
-	broadcast("gps", logMsg)
+	fixes := sourceFixes[id]
+	if own, ok := fixes[loc.Source]; ok && olderThan(loc.UpdatedAt, own.UpdatedAt) {
+		gpsMutex.Unlock()
+		return &staleError{ID: id, Stored: own.UpdatedAt}
+	}
+	prev, ok := gpsLocations.get(id)
+	if loc.TTL == 0 {
+		loc.TTL = prev.TTL
+	}
+	if fixes == nil {
+		fixes = make(map[string]GPSLocation)
+		sourceFixes[id] = fixes
+	}
+	fixes[loc.Source] = loc
+
+	// Another source may remain authoritative, in which case the fix is
+	// kept for later but the tracker's position does not move.
+	if chosen := chooseFix(fixes); chosen != loc {
+		gpsMutex.Unlock()
+		log.Printf("Location from %s for %s recorded; position stays with %s", sourceName(loc.Source), id, sourceName(chosen.Source))
+		return nil
+	}
 
-	fmt.Fprintf(w, "GPS updated for %s: %.6f, %.6f\n", id, lat, lon)
-}
+	// The sweeper may not have noticed the gap yet.
+	gap, trusted := elapsed(prev.UpdatedAt, loc.UpdatedAt)
+	gapped := ok && trusted && *gpsGap > 0 && gap > *gpsGap && markUncertain(id)
+	gpsLocations.set(id, loc)
+	appendTrack(loc)
+	trail := recentTrail(id)
+	gpsMutex.Unlock()
+	markDirty()
 
-func updateHandler(w http.ResponseWriter, r *http.Request) {
-	// log.Printf("Received Update request: %v", r.URL.Query())
-	id := r.URL.Query().Get("id")
-	val := r.URL.Query().Get("value")
+	if gapped {
+		logMsg := gapMessage(id, prev.UpdatedAt)
+		log.Println(logMsg)
+		broadcastFor(id, "gps-uncertain", logMsg)
+	}
 
-	if id == "" {
-		http.Error(w, "Missing id param", http.StatusBadRequest)
-		return
+	logMsg := fmt.Sprintf("Location update received for %s %.6f, %.6f", id, loc.Lat, loc.Lon)
+	if loc.Source != "" {
+		logMsg += " from " + loc.Source
+	}
+	log.Println(logMsg + describeCaller(ctx))
+	origin := originFrom(ctx)
+	broadcastMessage(SSEMessage{
+		Type:    "gps",
+		ID:      id,
+		Lat:     &loc.Lat,
+		Lon:     &loc.Lon,
+		Source:  loc.Source,
+		Trail:   trail,
+		Origin:  origin,
+		Message: logMsg + describeOrigin(origin),
+		Channel: channelGPS,
+		Time:    time.Now(),
+		span:    trace.SpanContextFromContext(ctx),
+	})
+	if ok {
+		checkSpeed(prev, loc)
 	}
+	checkGeofences(loc)
+	return nil
+}
 
-	if val == "" {
-		http.Error(w, "Missing value param", http.StatusBadRequest)
-		return
+// sourceName labels a GPS source in log messages.
+func sourceName(source string) string {
+	if source == "" {
+		return "the default source"
 	}
+	return source
+}
 
-	parsed, err := strconv.ParseBool(val)
-	if err != nil {
-		http.Error(w, "Invalid boolean value", http.StatusBadRequest)
-		return
+// recordDevice stores a device's attendance and broadcasts it, returning a
+// *staleError if the update is older than the stored state and a *heldError
+// if hysteresis holds the change back. A zero TTL keeps the device's
+// previous override. The broadcast is traced as part of the span in ctx.
+func recordDevice(ctx context.Context, dev DeviceState) error {
+	id := dev.ID
+	if err := checkDeviceID(id); err != nil {
+		return err
 	}
+	defer lockID(id)()
 
 	mutex.Lock()
-	devices[id] = parsed
+	prev, ok := devices.get(id)
+	if ok && olderThan(dev.UpdatedAt, prev.UpdatedAt) {
+		mutex.Unlock()
+		return &staleError{ID: id, Stored: prev.UpdatedAt}
+	}
+	if ok {
+		if err := settle(dev, prev); err != nil {
+			mutex.Unlock()
+			log.Println(err)
+			return err
+		}
+	}
+	if dev.TTL == 0 {
+		dev.TTL = prev.TTL
+	}
+	first := devices.len() == 0
+	devices.set(id, dev)
+	appendDeviceHistory(dev)
 	mutex.Unlock()
+	markDirty()
 
 	var logMsg string
-	if parsed {
+	if dev.Value {
 		logMsg = fmt.Sprintf("Attendance registered for %s", id)
 	} else {
 		logMsg = fmt.Sprintf("Attendance unregistered for %s", id)
 	}
-	log.Println(logMsg)
-	broadcast("update", logMsg)
+	log.Println(logMsg + describeCaller(ctx))
+	origin := originFrom(ctx)
+	broadcastMessage(SSEMessage{
+		Type:    "update",
+		ID:      id,
+		Reason:  dev.Reason,
+		Origin:  origin,
+		Message: logMsg + describeOrigin(origin),
+		Channel: channelAttendance,
+		Time:    time.Now(),
+		span:    trace.SpanContextFromContext(ctx),
+	})
+	if first {
+		announceOccupancy("first-device", fmt.Sprintf("First device %s arrived", id))
+	}
+	return nil
+}
+
+func updateHandler(w http.ResponseWriter, r *http.Request) error {
+	// log.Printf("Received Update request: %v", r.URL.Query())
+	p, err := validate(r.URL.Query(), deviceValidators)
+	if err != nil {
+		return err
+	}
+
+	if err := recordDevice(r.Context(), p.device()); err != nil {
+		var stale *staleError
+		if errors.As(err, &stale) {
+			return staleUpdate(w, stale)
+		}
+		var held *heldError
+		if errors.As(err, &held) {
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, "Device %s change to %v pending\n", p.ID, p.Value)
+			return nil
+		}
+		return err
+	}
 
-	fmt.Fprintf(w, "Device %s set to %v\n", id, parsed)
+	writeDone(w, "Device %s set to %v\n", p.ID, p.Value)
+	return nil
 }
 
 func getOutboundIP() net.IP {
@@ -237,6 +532,119 @@ func getOutboundIP() net.IP {
 }
 
 func main() {
+	flag.Parse()
+	if *staleUpdates != "reject" && *staleUpdates != "ignore" {
+		log.Fatalf("invalid -stale-updates %q: want reject or ignore", *staleUpdates)
+	}
+	if err := parseSourcePolicy(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadAuthenticator(); err != nil {
+		log.Fatal(err)
+	}
+	if *sseAuthChallenge && !authRequired() {
+		log.Fatal("-sse-auth-challenge requires -api-key or another -auth backend")
+	}
+	if *sweepPeriod <= 0 {
+		log.Fatal("-ttl-sweep-interval must be positive")
+	}
+	if *protectReads && !authRequired() {
+		log.Fatal("-protect-reads requires -api-key or another -auth backend")
+	}
+	if err := parseIDNamespaces(); err != nil {
+		log.Fatal(err)
+	}
+	if err := parseFieldNames(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadResponseHeaders(); err != nil {
+		log.Fatal(err)
+	}
+	if *valueTokens != "strict" && *valueTokens != "extended" {
+		log.Fatalf("invalid -value-tokens %q: want strict or extended", *valueTokens)
+	}
+	if *onRestart != "restore" && *onRestart != "clear" {
+		log.Fatalf("invalid -on-restart %q: want restore or clear", *onRestart)
+	}
+	if *writeResponse != "text" && *writeResponse != "204" {
+		log.Fatalf("invalid -write-response %q: want text or 204", *writeResponse)
+	}
+	if *geofenceBuffer < 0 || *geofenceConfirm < 1 {
+		log.Fatal("-geofence-buffer must not be negative and -geofence-confirm must be at least 1")
+	}
+	if *riseCount < 1 || *fallCount < 1 {
+		log.Fatal("-rise-count and -fall-count must be at least 1")
+	}
+	if _, ok := metersPerSecond[*speedUnits]; !ok {
+		log.Fatalf("invalid -speed-units %q: want kmh, mph or ms", *speedUnits)
+	}
+	if *speedLimit < 0 || math.IsNaN(*speedLimit) || math.IsInf(*speedLimit, 0) || *speedHysteresis < 0 || *speedHysteresis >= 1 || math.IsNaN(*speedHysteresis) {
+		log.Fatal("-speed-limit must be a finite number, not negative, and -speed-hysteresis must be at least 0 and below 1")
+	}
+	if *eventOrigin != "off" && *eventOrigin != "ip" && *eventOrigin != "key" {
+		log.Fatalf("invalid -event-origin %q: want off, ip or key", *eventOrigin)
+	}
+	if *originAnonymize != "none" && *originAnonymize != "truncate" && *originAnonymize != "hash" {
+		log.Fatalf("invalid -origin-anonymize %q: want none, truncate or hash", *originAnonymize)
+	}
+	if *maxUpdateAge < 0 || *maxFutureSkew < 0 {
+		log.Fatal("-max-update-age and -max-future-skew must not be negative")
+	}
+	if *snapshotInterval < 0 {
+		log.Fatal("-snapshot-interval must not be negative")
+	}
+	if *bindRetry < 0 || *bindRetryInterval <= 0 {
+		log.Fatal("-bind-retry must not be negative and -bind-retry-interval must be positive")
+	}
+	if *aggregateMode != "sliding" && *aggregateMode != "tumbling" {
+		log.Fatalf("invalid -aggregate-mode %q: want sliding or tumbling", *aggregateMode)
+	}
+	if *aggregateWindow < 0 || *aggregateBuckets < 1 {
+		log.Fatal("-aggregate-window must not be negative and -aggregate-buckets must be at least 1")
+	}
+	if *aggregateWindow > 0 {
+		aggregates = newAggregator(*aggregateWindow, *aggregateMode, *aggregateBuckets)
+	}
+	if *duplicateClients != "allow" && *duplicateClients != "replace" {
+		log.Fatalf("invalid -duplicate-clients %q: want allow or replace", *duplicateClients)
+	}
+	if *ssePadding < 0 {
+		log.Fatal("-sse-padding must not be negative")
+	}
+	if *rateLimit < 0 || (*rateLimit > 0 && *rateBurst < 1) {
+		log.Fatal("-rate-limit must not be negative and -rate-burst must be at least 1")
+	}
+	if *reconnectBase <= 0 || *reconnectMax < *reconnectBase || *reconnectJitter < 0 || *reconnectJitter > 1 {
+		log.Fatal("-reconnect-base must be positive, -reconnect-max at least -reconnect-base and -reconnect-jitter between 0 and 1")
+	}
+	if *logSampleRate < 0 || *logSampleRate > 1 {
+		log.Fatal("-log-sample-rate must be between 0 and 1")
+	}
+	if *maxQueryLimit <= 0 {
+		log.Fatal("-max-query-limit must be positive")
+	}
+	if *natsBuffer <= 0 || *natsSubject == "" || strings.ContainsAny(*natsSubject, " \t\r\n") {
+		log.Fatal("-nats-buffer must be positive and -nats-subject a non-empty subject without spaces")
+	}
+	if *saturationThreshold < 0 || *saturationThreshold > 1 || *saturationWindow <= 0 {
+		log.Fatal("-saturation-threshold must be between 0 and 1 and -saturation-window positive")
+	}
+	if *stalePresence != "keep" && *stalePresence != "downgrade" {
+		log.Fatalf("invalid -stale-presence %q: want keep or downgrade", *stalePresence)
+	}
+	if *routeMatching != "strict" && *routeMatching != "lenient" {
+		log.Fatalf("invalid -route-matching %q: want strict or lenient", *routeMatching)
+	}
+	if *eventIDs != "instance" && *eventIDs != "seq" {
+		log.Fatalf("invalid -event-ids %q: want instance or seq", *eventIDs)
+	}
+	if *maxBatch <= 0 {
+		log.Fatal("-max-batch must be positive")
+	}
+	if *trackLength < 0 {
+		log.Fatal("-track-length must not be negative")
+	}
+
 	currentTime := time.Now().Format("2006-01-02_15:04:05")
 	logFileName := fmt.Sprintf("server_%s.log", currentTime)
 	f, err := os.OpenFile(logFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
@@ -244,25 +652,198 @@ func main() {
 		log.Fatalf("error opening file: %v", err)
 	}
 	defer f.Close()
-	wrt := io.MultiWriter(os.Stdout, f)
-	log.SetOutput(wrt)
-	log.SetFlags(log.LstdFlags)
+	logPath.Store(logFileName)
+	fileLevel, err := parseLevel(*logLevelFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stdoutLevel, err := parseLevel(*logLevelStdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	wrt := io.MultiWriter(levelWriter{os.Stdout, stdoutLevel}, levelWriter{f, fileLevel})
+	if err := setLogOutput(wrt); err != nil {
+		log.Fatal(err)
+	}
+
+	if *transformFile != "" {
+		if err := loadTransformProfiles(*transformFile); err != nil {
+			log.Fatalf("[ERROR] error loading transform profiles: %v", err)
+		}
+		log.Printf("Loaded %d transform profile(s)", len(transformProfiles))
+	}
+	if *geofenceFile != "" {
+		if err := loadGeofences(*geofenceFile); err != nil {
+			log.Fatalf("[ERROR] error loading geofences: %v", err)
+		}
+		log.Printf("Loaded %d geofence(s)", len(geofences))
+	}
+	if *rateLimitsFile != "" {
+		if err := loadRateLimits(*rateLimitsFile); err != nil {
+			log.Fatalf("[ERROR] error loading rate limits: %v", err)
+		}
+		log.Printf("Loaded rate limits for %d API key(s)", len(keyLimits))
+	}
+
+	if *otelEndpoint != "" {
+		shutdownTracing, err := setupTracing(context.Background())
+		if err != nil {
+			log.Fatalf("[ERROR] error setting up tracing: %v", err)
+		}
+		defer shutdownTracing(context.Background())
+		log.Printf("Exporting traces to %s", *otelEndpoint)
+	}
 
 	broker = NewBroker()
 
-	http.HandleFunc("/update", updateHandler)
-	http.HandleFunc("/gps", gpsHandler)
-	http.HandleFunc("/history", historyHandler)
-	http.HandleFunc("/clear", clearHandler)
-	http.Handle("/events", broker)
+	background.Go(func(ctx context.Context) { sweepExpired(ctx, *sweepPeriod) })
+	if *gpsGap > 0 {
+		background.Go(func(ctx context.Context) { sweepGaps(ctx, *gpsGap) })
+	}
+	if *quietWindow > 0 {
+		go watchQuiet(*quietWindow)
+	}
+	if *natsURL != "" {
+		go runNATS(*natsURL)
+	}
+	if *webhookURL != "" {
+		go runWebhook(*webhookURL)
+	}
+	if *snapshotInterval > 0 {
+		go broadcastSnapshots(*snapshotInterval)
+	}
+	if *saturationThreshold > 0 {
+		go watchSaturation(*saturationThreshold, *saturationWindow)
+	}
+	if *memLimitMB > 0 {
+		background.Go(func(ctx context.Context) { watchMemory(ctx, *memLimitMB<<20, *memCheckEvery) })
+	}
+
+	// Short-lived endpoints are rate limited, share the -max-inflight limit
+	// and the -request-timeout and are compressed; the long-lived SSE
+	// streams are registered directly so they never hold a slot, time out
+	// or buffer.
+	var inflight chan struct{}
+	if *maxInFlight > 0 {
+		inflight = make(chan struct{}, *maxInFlight)
+	}
+	if *rateLimit > 0 || len(keyLimits) > 0 {
+		go sweepBuckets(time.Minute)
+	}
+	handle := func(pattern string, h http.Handler) {
+		http.Handle(pattern, checkParams(pattern, withRateLimit(withCompression(limitConcurrency(inflight, withTimeout(*requestTimeout, h))))))
+	}
 
-	// Serve embedded index.html at root
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		w.Write(indexHTML)
-	})
+	handle("/update", trackInbound(requireAPIKey(checkNonce(apiHandler(updateHandler)))))
+	handle("/gps", trackInbound(requireAPIKey(checkNonce(apiHandler(gpsHandler)))))
+	handle("/update/batch", trackInbound(requireAPIKey(checkNonce(apiHandler(updateBatchHandler)))))
+	handle("/gps/batch", trackInbound(requireAPIKey(checkNonce(apiHandler(gpsBatchHandler)))))
+	handle("/history", requireRead(apiHandler(historyHandler)))
+	handle("/activity", requireRead(apiHandler(activityHandler)))
+	handle("/journal", requireRead(apiHandler(journalHandler)))
+	handle("/aggregates", requireRead(apiHandler(aggregatesHandler)))
+	handle("/gps.geojson", requireRead(apiHandler(geoJSONHandler)))
+	handle("/gps/heatmap", requireRead(apiHandler(heatmapHandler)))
+	handle("/geofence/membership", requireRead(apiHandler(geofenceMembershipHandler)))
+	handle("/devices", requireRead(apiHandler(devicesHandler)))
+	handle("/locations", requireRead(apiHandler(locationsHandler)))
+	handle("/track", requireRead(apiHandler(trackHandler)))
+	handle("/duration", requireRead(apiHandler(durationHandler)))
+	handle("/entity/{id}", requireRead(apiHandler(entityHandler)))
+	handle("/view", requireRead(withCSP(apiHandler(viewHandler))))
+	handle("/clear", requireAPIKey(checkNonce(apiHandler(clearHandler))))
+	handle("/publish", requireAPIKey(checkNonce(apiHandler(publishHandler))))
+	handle("/note", requireAPIKey(checkNonce(apiHandler(noteHandler))))
+	handle("/version", requireRead(apiHandler(versionHandler)))
+	handle("/time", apiHandler(timeHandler))
+	handle("/ready", apiHandler(readyHandler))
+	handle("/stats", requireRead(apiHandler(statsHandler)))
+	handle("/metrics", requireRead(apiHandler(metricsHandler)))
+	handle("/webhook/deliveries", requireRead(apiHandler(webhookDeliveriesHandler)))
+	handle("/webhook/devices", requireAdmin(apiHandler(deviceWebhooksHandler)))
+	handle("/events/dump", requireRead(apiHandler(dumpHandler)))
+	handle("/events/auth", requireAPIKey(apiHandler(sseAuthHandler)))
+	http.Handle("/events", checkParams("/events", requireStreamRead(broker)))
+	http.Handle("/logs/stream", checkParams("/logs/stream", requireAdmin(apiHandler(logStreamHandler))))
+	handle("/sign", requireAdmin(apiHandler(signHandler)))
+	handle("/debug/state", requireAdmin(apiHandler(debugStateHandler)))
+	handle("/import/gpx", requireAdmin(apiHandler(importGPXHandler)))
+	handle("/persist", requireAdmin(apiHandler(persistHandler)))
+	handle("/prune", requireAdmin(apiHandler(pruneHandler)))
+	handle("/speed-limits", requireAdmin(apiHandler(speedLimitsHandler)))
+	handle("/simulate/route", requireAdmin(apiHandler(simulateRouteHandler)))
+	handle("/simulate/route/stop", requireAdmin(apiHandler(simulateStopHandler)))
+
+	// Serve embedded index.html (or -webroot) at root
+	handle("/", requireRead(withCSP(rootHandler())))
+	handle("/m", requireRead(withCSP(mobileHandler())))
+
+	if *devMode {
+		if *webroot == "" {
+			log.Fatal("[ERROR] -dev requires -webroot")
+		}
+		if err := watchWebroot(*webroot); err != nil {
+			log.Fatalf("[ERROR] error watching webroot: %v", err)
+		}
+		log.Printf("Dev mode: watching %s for changes", *webroot)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Addr:      ":8080",
+		Handler:   withRequestLog(withTracing(withResponseHeaders(withCORS(withInstance(withPprof(withOrigin(withReadiness(withLenientRoutes(http.DefaultServeMux))))))))),
+		ConnState: trackConn,
+	}
+	ln, err := listen(srv.Addr)
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("[ERROR] ", err)
+		}
+	}()
+
+	// The listener answers 503 until the saved state is fully loaded; a
+	// write accepted earlier could be overwritten by the older saved value.
+	// The TCP listener, which cannot say so, only starts afterwards.
+	var persistStop, persistDone chan struct{}
+	if *stateFile != "" {
+		if err := startState(*stateFile); err != nil {
+			log.Fatalf("[ERROR] error loading state: %v", err)
+		}
+		persistStop, persistDone = make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(persistDone)
+			persistLoop(persistStop, *stateFile, *persistInterval)
+		}()
+	}
+	ready.Store(true)
+
+	var tcpDone chan struct{}
+	if *tcpGPSPort > 0 {
+		tcpDone = make(chan struct{})
+		go func() {
+			defer close(tcpDone)
+			if err := serveTCPGPS(ctx, fmt.Sprintf(":%d", *tcpGPSPort)); err != nil {
+				log.Fatalf("[ERROR] error starting TCP GPS listener: %v", err)
+			}
+		}()
+	}
 
 	ip := getOutboundIP()
 	log.Printf("Server running on %s:8080\n", ip.String())
-	log.Fatal(http.ListenAndServe(":8080", nil))
+
+	<-ctx.Done()
+	log.Println("Shutting down")
+
+	shutdownHTTP(srv)
+	if tcpDone != nil {
+		<-tcpDone
+	}
+	// Both listeners are drained, so no request can race the final flush.
+	flushOnShutdown(persistStop, persistDone)
+	log.Println("Server stopped")
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	if err := loadAuthenticator(); err != nil {
+		log.Fatal(err)
+	}
+	broker = NewBroker()
+	os.Exit(m.Run())
+}
+
+// resetState waits for the previous test's streams to close, then empties
+// the devices, trackers and history, so each test starts from a fresh
+// server.
+func resetState(t *testing.T) {
+	t.Helper()
+	waitForClients(t, 0)
+	mutex.Lock()
+	devices.update(func(m map[string]DeviceState) { clear(m) })
+	clear(pendingChanges)
+	clear(deviceHistory)
+	mutex.Unlock()
+
+	gpsMutex.Lock()
+	gpsLocations.update(func(m map[string]GPSLocation) { clear(m) })
+	clear(tracks)
+	clear(sourceFixes)
+	gpsMutex.Unlock()
+
+	historyMutex.Lock()
+	history = nil
+	historyMutex.Unlock()
+}
+
+// setFlag sets a flag value for the duration of the test.
+func setFlag[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// serve runs h on a request for target and returns the recorded response.
+func serve(h http.Handler, method, target string, body io.Reader) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, body))
+	return w
+}
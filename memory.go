@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	memLimitMB    = flag.Uint64("mem-limit-mb", 0, "heap size in MiB above which history buffers are trimmed (0 disables)")
+	memCheckEvery = flag.Duration("mem-check-interval", 5*time.Second, "how often the memory watchdog samples the heap")
+)
+
+// degradedDivisor is how much buffers shrink in degraded mode.
+const degradedDivisor = 10
+
+// degraded is set while the heap is above -mem-limit-mb.
+var degraded atomic.Bool
+
+func memoryMode() string {
+	if degraded.Load() {
+		return "degraded"
+	}
+	return "normal"
+}
+
+// historyLimit is the current cap on the event history.
+func historyLimit() int {
+	if degraded.Load() {
+		return max(maxHistory/degradedDivisor, 1)
+	}
+	return maxHistory
+}
+
+// trackLimit is the current cap on each tracker's track. Degraded mode
+// still keeps the newest fix unless -track-length disables tracks.
+func trackLimit() int {
+	if degraded.Load() {
+		return min(*trackLength, max(*trackLength/degradedDivisor, 1))
+	}
+	return *trackLength
+}
+
+// watchMemory switches to degraded mode when the heap grows past limit and
+// back once it drops below 80% of it, so the server sheds buffered history
+// instead of being OOM-killed. It runs until ctx is cancelled.
+func watchMemory(ctx context.Context, limit uint64, interval time.Duration) {
+	var ms runtime.MemStats
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		runtime.ReadMemStats(&ms)
+		switch {
+		case !degraded.Load() && ms.HeapAlloc > limit:
+			degraded.Store(true)
+			trimBuffers()
+			log.Printf("Memory degraded: heap %d MiB over limit %d MiB, history capped at %d",
+				ms.HeapAlloc>>20, limit>>20, historyLimit())
+		case degraded.Load() && ms.HeapAlloc < limit/10*8:
+			degraded.Store(false)
+			log.Printf("Memory recovered: heap %d MiB, normal limits restored", ms.HeapAlloc>>20)
+		}
+	}
+}
+
+// trimBuffers cuts the history and tracks down to the current limits.
+func trimBuffers() {
+	historyMutex.Lock()
+	if n := historyLimit(); len(history) > n {
+		history = append([]SSEMessage(nil), history[len(history)-n:]...)
+	}
+	historyMutex.Unlock()
+
+	gpsMutex.Lock()
+	n := trackLimit()
+	for id, track := range tracks {
+		if len(track) > n {
+			tracks[id] = append([]GPSLocation(nil), track[len(track)-n:]...)
+		}
+	}
+	gpsMutex.Unlock()
+}
@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+func TestDegradedTrackLimit(t *testing.T) {
+	degraded.Store(true)
+	t.Cleanup(func() { degraded.Store(false) })
+
+	for length, want := range map[int]int{0: 0, 1: 1, 5: 1, 100: 10} {
+		setFlag(t, trackLength, length)
+		if got := trackLimit(); got != want {
+			t.Errorf("-track-length=%d: degraded limit = %d, want %d", length, got, want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency
+// histograms. The handlers mostly answer from memory, so the buckets are
+// concentrated below 100ms.
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// histogram counts observations per bucket; counts has one extra slot for
+// observations above the last bound.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// histogramVec is a family of histograms told apart by one label.
+type histogramVec struct {
+	mu       sync.Mutex
+	byLabel  map[string]*histogram
+	name     string
+	help     string
+	labelKey string
+}
+
+func newHistogramVec(name, help, labelKey string) *histogramVec {
+	return &histogramVec{byLabel: make(map[string]*histogram), name: name, help: help, labelKey: labelKey}
+}
+
+// observe records one observation of d under label.
+func (v *histogramVec) observe(label string, d time.Duration) {
+	secs := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets, secs)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h := v.byLabel[label]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		v.byLabel[label] = h
+	}
+	h.counts[i]++
+	h.sum += secs
+	h.count++
+}
+
+// write renders the family in the Prometheus text exposition format.
+func (v *histogramVec) write(w io.Writer) {
+	v.mu.Lock()
+	labels := make([]string, 0, len(v.byLabel))
+	snapshot := make(map[string]histogram, len(v.byLabel))
+	for label, h := range v.byLabel {
+		labels = append(labels, label)
+		snapshot[label] = histogram{counts: append([]uint64(nil), h.counts...), sum: h.sum, count: h.count}
+	}
+	v.mu.Unlock()
+	sort.Strings(labels)
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", v.name)
+	for _, label := range labels {
+		h := snapshot[label]
+		l := v.labelKey + "=" + strconv.Quote(label)
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", v.name, l, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", v.name, l, h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", v.name, l, h.sum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", v.name, l, h.count)
+	}
+}
+
+// requestDurations is keyed by mux pattern.
+var requestDurations = newHistogramVec("http_request_duration_seconds", "Time taken to answer HTTP requests, by endpoint.", "endpoint")
+
+// observeLatency records one request to endpoint that took d.
+func observeLatency(endpoint string, d time.Duration) {
+	requestDurations.observe(endpoint, d)
+}
+
+// metricsHandler serves the request duration histograms, the broker
+// occupancy gauges and, with -delivery-timing, the event delivery
+// histograms in the Prometheus text exposition format. Streaming responses
+// such as /events are left out of the former, since their duration is the
+// lifetime of the connection.
+func metricsHandler(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	bw := bufio.NewWriter(w)
+	requestDurations.write(bw)
+	writeSaturationMetrics(bw)
+	if *deliveryTiming {
+		deliveryDurations.write(bw)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var (
+	fieldNaming = flag.String("field-names", "default", "JSON key naming of events and read responses: default, long (latitude/longitude/present) or camel (camelCase)")
+	fieldMap    = flag.String("field-map", "", "comma-separated old=new key renames applied on top of -field-names, e.g. lat=y,lon=x")
+)
+
+// longNames spells out the terse keys some downstream schemas reject.
+var longNames = map[string]string{
+	"lat":   "latitude",
+	"lon":   "longitude",
+	"value": "present",
+}
+
+// fieldName maps a key to its configured name; nil means keys are unchanged.
+var fieldName func(string) string
+
+// parseFieldNames builds fieldName from -field-names and -field-map.
+func parseFieldNames() error {
+	var base func(string) string
+	switch *fieldNaming {
+	case "default":
+	case "long":
+		base = func(k string) string {
+			if n, ok := longNames[k]; ok {
+				return n
+			}
+			return k
+		}
+	case "camel":
+		base = camelCase
+	default:
+		return fmt.Errorf("invalid -field-names %q: want default, long or camel", *fieldNaming)
+	}
+
+	overrides := make(map[string]string)
+	for _, pair := range strings.Split(*fieldMap, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, "=")
+		if !ok || from == "" || to == "" {
+			return fmt.Errorf("invalid -field-map entry %q: want old=new", pair)
+		}
+		overrides[from] = to
+	}
+
+	if base == nil && len(overrides) == 0 {
+		return nil
+	}
+	fieldName = func(k string) string {
+		if n, ok := overrides[k]; ok {
+			return n
+		}
+		if base != nil {
+			return base(k)
+		}
+		return k
+	}
+	return nil
+}
+
+// camelCase turns snake_case into camelCase.
+func camelCase(k string) string {
+	parts := strings.Split(k, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// renameKeys applies fieldName to every object key in a decoded JSON value.
+func renameKeys(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, val := range t {
+			out[fieldName(k)] = renameKeys(val)
+		}
+		return out
+	case []any:
+		for i, item := range t {
+			t[i] = renameKeys(item)
+		}
+	}
+	return v
+}
+
+// renameFields round-trips v through JSON with its keys renamed. Keys of the
+// result are emitted in sorted order.
+func renameFields(v any) (any, error) {
+	if fieldName == nil {
+		return v, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic any
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return nil, err
+	}
+	return renameKeys(generic), nil
+}
+
+// renameEvent is renameFields for an encoded event.
+func renameEvent(event []byte) []byte {
+	if fieldName == nil {
+		return event
+	}
+	var generic any
+	if err := json.Unmarshal(event, &generic); err != nil {
+		return event
+	}
+	out, err := json.Marshal(renameKeys(generic))
+	if err != nil {
+		return event
+	}
+	return out
+}
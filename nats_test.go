@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Events are published to the subject of their type even when -field-map
+// or -cloudevents moves the type out of "type" in the payload.
+func TestNATSSubjectWithFieldMap(t *testing.T) {
+	quiesce(t, broker)
+	setFlag(t, fieldMap, "type=kind")
+	if err := parseFieldNames(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fieldName = nil })
+
+	msg := SSEMessage{Type: "gps", ID: "trk-1", Message: "moved", Time: time.Now()}
+	raw, _ := json.Marshal(msg)
+	for _, cloud := range []bool{false, true} {
+		data, err := encoding{cloudEvents: cloud}.encode(msg, renameEvent(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued := natsMessageFor(encodedEvent{data: data, msg: &msg})
+
+		client, server := net.Pipe()
+		nc := &natsConn{conn: client, w: bufio.NewWriter(client), dead: make(chan struct{})}
+		queue := make(chan natsMessage)
+		go nc.publishFrom(queue, queued)
+
+		line, err := bufio.NewReader(server).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(line, "PUB events.gps ") {
+			t.Errorf("cloudevents=%v: published with %q, want subject events.gps", cloud, strings.TrimSpace(line))
+		}
+		nc.fail(net.ErrClosed)
+		client.Close()
+		server.Close()
+	}
+}
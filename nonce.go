@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	requireNonce = flag.Bool("require-nonce", false, "require a unique ?nonce= and a current ?ts= on write endpoints to block replayed requests")
+	nonceSkew    = flag.Duration("nonce-skew", 30*time.Second, "how far ?ts= may differ from server time when -require-nonce is set")
+)
+
+const (
+	codeReplayed   = "replayed_request"
+	maxNonceLength = 128
+)
+
+// nonceCache remembers nonces until their request timestamp falls outside
+// the skew window, after which the timestamp check alone rejects a replay.
+type nonceCache struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time // nonce -> expiry
+	nextGC time.Time
+}
+
+var nonces = &nonceCache{seen: make(map[string]time.Time)}
+
+// use records nonce and reports whether it had not been seen before.
+func (c *nonceCache) use(nonce string, expiry time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.After(c.nextGC) {
+		for n, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, n)
+			}
+		}
+		c.nextGC = now.Add(*nonceSkew)
+	}
+
+	if exp, ok := c.seen[nonce]; ok && now.Before(exp) {
+		return false
+	}
+	c.seen[nonce] = expiry
+	return true
+}
+
+// checkNonce rejects write requests that reuse a nonce or carry a timestamp
+// outside -nonce-skew of the server clock. It is a no-op unless
+// -require-nonce is set.
+func checkNonce(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !*requireNonce {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		nonce := r.URL.Query().Get("nonce")
+		if nonce == "" {
+			writeError(w, missingParam("nonce"))
+			return
+		}
+		if len(nonce) > maxNonceLength {
+			writeError(w, invalidParam("nonce"))
+			return
+		}
+		tsStr := r.URL.Query().Get("ts")
+		if tsStr == "" {
+			writeError(w, missingParam("ts"))
+			return
+		}
+		ts, err := time.Parse(time.RFC3339Nano, tsStr)
+		if err != nil {
+			writeError(w, invalidParam("ts"))
+			return
+		}
+		if skew := time.Since(ts); skew > *nonceSkew || skew < -*nonceSkew {
+			writeError(w, newAPIError(http.StatusBadRequest, codeReplayed, "Request timestamp outside allowed clock skew"))
+			return
+		}
+		if !nonces.use(nonce, ts.Add(*nonceSkew)) {
+			writeError(w, newAPIError(http.StatusConflict, codeReplayed, "Nonce already used"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxNoteBytes bounds an operator note; it is meant for a one-line banner.
+const maxNoteBytes = 1 << 10
+
+var (
+	noteMutex   sync.Mutex
+	currentNote *SSEMessage // the note shown on dashboards, nil when cleared
+)
+
+// noteHandler sets the operator note with POST, taking the message from the
+// body or ?message= and ?level=info|warn, and clears it with DELETE. Both
+// are broadcast, and newly connected SSE clients receive the current note.
+func noteHandler(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodPost:
+		level := r.URL.Query().Get("level")
+		if level == "" {
+			level = "info"
+		}
+		if level != "info" && level != "warn" {
+			return invalidParam("level")
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxNoteBytes+1))
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, codeInvalidParam, "Failed to read body")
+		}
+		if len(body) > maxNoteBytes {
+			return newAPIError(http.StatusRequestEntityTooLarge, codeInvalidParam, "Note too large")
+		}
+		message := string(body)
+		if message == "" {
+			message = r.URL.Query().Get("message")
+		}
+		if message == "" {
+			return missingParam("message")
+		}
+
+		note := SSEMessage{Type: "note", Level: level, Message: message, Channel: channelSystem, Time: time.Now()}
+		log.Printf("Operator note set (%s): %s", level, message)
+		// Broadcasting under the lock keeps the events in the order the
+		// notes were set.
+		noteMutex.Lock()
+		currentNote = &note
+		broadcastMessage(note)
+		noteMutex.Unlock()
+
+		w.Write([]byte("Note set"))
+		return nil
+	case http.MethodDelete:
+		noteMutex.Lock()
+		if currentNote == nil {
+			noteMutex.Unlock()
+			return newAPIError(http.StatusNotFound, codeNotFound, "No note is set")
+		}
+		currentNote = nil
+		broadcast("note-clear", "Note cleared")
+		noteMutex.Unlock()
+		log.Println("Operator note cleared")
+
+		w.Write([]byte("Note cleared"))
+		return nil
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		return newAPIError(http.StatusMethodNotAllowed, codeBadMethod, "Use POST or DELETE")
+	}
+}
+
+// noteEvents returns the current note as the events a new SSE client is
+// sent on connecting, if one is set.
+func noteEvents() []SSEMessage {
+	noteMutex.Lock()
+	defer noteMutex.Unlock()
+	if currentNote == nil {
+		return nil
+	}
+	return []SSEMessage{*currentNote}
+}
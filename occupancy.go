@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+)
+
+var occupancyEvents = flag.Bool("occupancy-events", false, "broadcast first-device when the first device appears and all-gone when the last one expires")
+
+// announceOccupancy broadcasts a change between no devices and some devices,
+// for idle screens that switch on the first arrival.
+func announceOccupancy(event, message string) {
+	if !*occupancyEvents {
+		return
+	}
+	log.Println(message)
+	broadcastMessage(SSEMessage{Type: "system", Event: event, Message: message, Channel: channelSystem, Time: time.Now()})
+}
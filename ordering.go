@@ -0,0 +1,31 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// idShards is how many locks the ids are spread over. Updates to ids in
+// different shards never wait for each other.
+const idShards = 64
+
+// idLocks orders the updates of each id from storing to broadcasting.
+// recordDevice and recordGPS release the store locks before broadcasting,
+// so without them two concurrent updates for one id could be broadcast in
+// the opposite order to the one they were stored in, leaving clients with
+// the older state.
+var idLocks [idShards]sync.Mutex
+
+// lockID locks the shard of id and returns the function unlocking it.
+func lockID(id string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	l := &idLocks[h.Sum32()%idShards]
+	l.Lock()
+	return l.Unlock
+}
+
+// broadcastMutex makes the order events reach the broker the order of
+// their sequence numbers. The broker fans out from a single goroutine, so
+// every client then receives events in the order they were broadcast.
+var broadcastMutex sync.Mutex
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Concurrent updates to the same ids must be broadcast in the order they
+// were stored, and delivered in sequence order, so the last event a client
+// sees for an id is the state the server kept.
+func TestConcurrentUpdatesKeepPerIDOrder(t *testing.T) {
+	resetState(t)
+	events, unsubscribe := broker.subscribe(&client{})
+	defer unsubscribe()
+	waitForClients(t, 1)
+
+	seqs := make(chan []uint64)
+	go func() {
+		var got []uint64
+		for ev := range events {
+			got = append(got, ev.seq)
+		}
+		seqs <- got
+	}()
+
+	ids := []string{"t1", "t2"}
+	var wg sync.WaitGroup
+	for w := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := ids[w%len(ids)]
+			for i := range 25 {
+				loc := GPSLocation{ID: id, Lat: float64(w), Lon: float64(i), UpdatedAt: time.Now()}
+				if err := recordGPS(context.Background(), loc); err != nil {
+					var stale *staleError
+					if !errors.As(err, &stale) {
+						t.Error(err)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	unsubscribe()
+
+	got := <-seqs
+	for i := 1; i < len(got); i++ {
+		if got[i] <= got[i-1] {
+			t.Fatalf("delivered seq %d after %d", got[i], got[i-1])
+		}
+	}
+
+	historyMutex.Lock()
+	last := make(map[string]string)
+	for _, msg := range history {
+		if msg.Type == "gps" {
+			last[msg.ID] = fmt.Sprint(*msg.Lat, *msg.Lon)
+		}
+	}
+	historyMutex.Unlock()
+	for _, id := range ids {
+		stored, _ := gpsLocations.get(id)
+		if want := fmt.Sprint(stored.Lat, stored.Lon); last[id] != want {
+			t.Errorf("%s: last broadcast at %s, stored at %s", id, last[id], want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"net"
+	"net/http"
+)
+
+var (
+	eventOrigin     = flag.String("event-origin", "off", "what update and gps events record about the request that caused them: off, ip (the client address) or key (a short id derived from the API key)")
+	originAnonymize = flag.String("origin-anonymize", "none", "how -event-origin=ip coarsens addresses: none, truncate (IPv4 /24, IPv6 /48) or hash (a salted hash, stable until restart)")
+	originUserAgent = flag.Bool("origin-user-agent", false, "also record the request's User-Agent in events when -event-origin is set")
+)
+
+// requestOrigin identifies who caused an event, for audit trails on the
+// dashboard.
+type requestOrigin struct {
+	Source    string `json:"source"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// originSalt keys -origin-anonymize=hash, so hashed addresses cannot be
+// reversed by hashing the whole IPv4 space.
+var originSalt = func() []byte {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return b
+}()
+
+type originKey struct{}
+
+// withOrigin records the origin of every request in its context, where
+// recordDevice and recordGPS pick it up. It is a no-op when -event-origin
+// is off.
+func withOrigin(next http.Handler) http.Handler {
+	if *eventOrigin == "off" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o := &requestOrigin{Source: originSource(r)}
+		if *originUserAgent {
+			o.UserAgent = r.UserAgent()
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), originKey{}, o)))
+	})
+}
+
+// originFrom returns the origin stored by withOrigin, or nil.
+func originFrom(ctx context.Context) *requestOrigin {
+	o, _ := ctx.Value(originKey{}).(*requestOrigin)
+	return o
+}
+
+// originSource describes r's caller per -event-origin. API keys are never
+// exposed: callers are named by the -auth backend, or by a hash prefix that
+// tells keys apart.
+func originSource(r *http.Request) string {
+	if *eventOrigin == "key" {
+		key := requestAPIKey(r)
+		if key == "" {
+			return "anonymous"
+		}
+		if id, ok := authenticator.Authenticate(r); ok && id.Name != "" {
+			return id.Name
+		}
+		sum := sha256.Sum256([]byte(key))
+		return "key-" + hex.EncodeToString(sum[:4])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	switch *originAnonymize {
+	case "truncate":
+		if ip := net.ParseIP(host); ip != nil {
+			if v4 := ip.To4(); v4 != nil {
+				return v4.Mask(net.CIDRMask(24, 32)).String()
+			}
+			return ip.Mask(net.CIDRMask(48, 128)).String()
+		}
+	case "hash":
+		sum := sha256.Sum256(append(originSalt[:len(originSalt):len(originSalt)], host...))
+		return "ip-" + hex.EncodeToString(sum[:6])
+	}
+	return host
+}
+
+// describeOrigin is the suffix event messages get when o is known.
+func describeOrigin(o *requestOrigin) string {
+	if o == nil {
+		return ""
+	}
+	return " from " + o.Source
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+)
+
+var maxQueryLimit = flag.Int("max-query-limit", 1000, "most items a history, dump or track query returns; larger ?limit values are clamped")
+
+// queryLimit returns the ?limit of r clamped to -max-query-limit, or the
+// maximum when no limit was asked for.
+func queryLimit(r *http.Request) (int, error) {
+	limit := *maxQueryLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			return 0, invalidParam("limit")
+		}
+		limit = min(n, limit)
+	}
+	return limit, nil
+}
+
+// markTruncated tells the client the result was cut short and where the
+// next page starts.
+func markTruncated(w http.ResponseWriter, next string) {
+	w.Header().Set("X-Truncated", "true")
+	w.Header().Set("X-Next-Cursor", next)
+}
+
+// eventsAfter returns up to limit buffered events with since < seq <= until,
+// oldest first. If more remain it marks the response truncated, with the seq
+// to pass as ?since= for the next page.
+func eventsAfter(w http.ResponseWriter, since, until uint64, limit int) []SSEMessage {
+	events := []SSEMessage{}
+	for _, msg := range history {
+		if msg.Seq <= since || msg.Seq > until {
+			continue
+		}
+		if len(events) == limit {
+			markTruncated(w, strconv.FormatUint(events[limit-1].Seq, 10))
+			break
+		}
+		events = append(events, msg)
+	}
+	return events
+}
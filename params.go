@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var strictParams = flag.Bool("strict-params", false, "reject requests carrying query params the endpoint does not know, to catch typos such as ?lng=")
+
+const codeUnknownParam = "unknown_param"
+
+// commonParams are accepted by every endpoint: authentication, signed URLs
+// and response shaping.
+var commonParams = []string{"api_key", "scope", "expires", "sig", "fields", "pretty"}
+
+// nonceParams are accepted by the endpoints behind checkNonce.
+var nonceParams = []string{"nonce", "ts"}
+
+// endpointParams lists the query params each route understands, keyed by
+// its mux pattern. Routes missing from it, such as the static files under
+// "/", are never checked.
+var endpointParams = map[string][]string{
+	"/update":              {"id", "value", "reason", "ts", "ttl", "nonce"},
+	"/gps":                 {"id", "lat", "lon", "ts", "ttl", "source", "nonce"},
+	"/update/batch":        nonceParams,
+	"/gps/batch":           nonceParams,
+	"/history":             {"since", "limit"},
+	"/journal":             {"id", "from", "to", "types", "since", "limit"},
+	"/activity":            {"window", "type"},
+	"/aggregates":          {},
+	"/devices":             {},
+	"/locations":           {"coord_format"},
+	"/gps.geojson":         {},
+	"/gps/heatmap":         {"precision", "window"},
+	"/track":               {"id", "offset", "limit", "coord_format"},
+	"/duration":            {"id", "from", "to"},
+	"/entity/{id}":         {},
+	"/view":                {"refresh"},
+	"/clear":               nonceParams,
+	"/publish":             append([]string{"channel", "type", "message"}, nonceParams...),
+	"/note":                append([]string{"message", "level"}, nonceParams...),
+	"/version":             {},
+	"/time":                {},
+	"/ready":               {},
+	"/stats":               {},
+	"/metrics":             {},
+	"/webhook/deliveries":  {"limit"},
+	"/webhook/devices":     {"id", "url"},
+	"/events/dump":         {"since", "until", "limit"},
+	"/events/auth":         {"token"},
+	"/events":              {"priority", "channel", "profile", "bbox", "format", "stats", "ids", "last_event_id", "client_id"},
+	"/logs/stream":         {},
+	"/sign":                {"path", "ttl"},
+	"/debug/state":         {},
+	"/import/gpx":          {"id", "replay", "speed"},
+	"/speed-limits":        {"id", "limit"},
+	"/simulate/route":      {"id", "speed", "tick"},
+	"/simulate/route/stop": {"id"},
+	"/geofence/membership": {"id"},
+	"/persist":             {},
+	"/prune":               {"older_than", "type"},
+}
+
+// checkParams rejects requests to pattern that carry unknown query params
+// when -strict-params is set, listing them in the error.
+func checkParams(pattern string, next http.Handler) http.Handler {
+	known, ok := endpointParams[pattern]
+	if !*strictParams || !ok {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var unknown []string
+		for key := range r.URL.Query() {
+			if !slices.Contains(known, key) && !slices.Contains(commonParams, key) {
+				unknown = append(unknown, key)
+			}
+		}
+		if len(unknown) > 0 {
+			slices.Sort(unknown)
+			writeError(w, newAPIError(http.StatusBadRequest, codeUnknownParam, "Unknown query params: "+strings.Join(unknown, ",")))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
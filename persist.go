@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	stateFile       = flag.String("state-file", "", "persist devices, locations and tracks to this JSON file and restore them at startup")
+	persistInterval = flag.Duration("persist-interval", 30*time.Second, "how often changed state is written to -state-file")
+	onRestart       = flag.String("on-restart", "restore", "what to do with an existing -state-file at startup: restore it or clear (ignore) it")
+	truncateState   = flag.Bool("truncate-state", false, "with -on-restart=clear, immediately overwrite the state file with empty state")
+	stateCompress   = flag.Bool("state-compress", false, "gzip the state file; implied when -state-file ends in .gz")
+)
+
+const codePersistDisabled = "persistence_disabled"
+
+// stateDirty is set whenever persisted state changes and cleared by a save.
+var stateDirty atomic.Bool
+
+func markDirty() {
+	stateDirty.Store(true)
+}
+
+// storedDevice and storedLocation add the fields hidden from API responses
+// that must survive a restart.
+type storedDevice struct {
+	DeviceState
+	TTL time.Duration `json:"ttl,omitempty"`
+}
+
+type storedLocation struct {
+	GPSLocation
+	TTL time.Duration `json:"ttl,omitempty"`
+}
+
+// savedState is the on-disk format of -state-file.
+type savedState struct {
+	SavedAt   time.Time                   `json:"saved_at"`
+	Devices   []storedDevice              `json:"devices"`
+	Locations []storedLocation            `json:"locations"`
+	Tracks    map[string][]storedLocation `json:"tracks,omitempty"`
+}
+
+func snapshotState() savedState {
+	state := savedState{SavedAt: time.Now(), Tracks: make(map[string][]storedLocation)}
+
+	for _, dev := range devices.snapshot() {
+		state.Devices = append(state.Devices, storedDevice{dev, dev.TTL})
+	}
+
+	gpsMutex.Lock()
+	for _, loc := range gpsLocations.snapshot() {
+		state.Locations = append(state.Locations, storedLocation{loc, loc.TTL})
+	}
+	for id, track := range tracks {
+		stored := make([]storedLocation, len(track))
+		for i, loc := range track {
+			stored[i] = storedLocation{loc, loc.TTL}
+		}
+		state.Tracks[id] = stored
+	}
+	gpsMutex.Unlock()
+
+	return state
+}
+
+// saveMutex serializes saveState. Without it a slow periodic save could
+// rename its older snapshot over the one a concurrent /persist just wrote.
+var saveMutex sync.Mutex
+
+// compressState reports whether the state file at path is written gzipped.
+func compressState(path string) bool {
+	return *stateCompress || strings.HasSuffix(path, ".gz")
+}
+
+// saveState atomically replaces path with the current state: it writes a
+// temporary file in the same directory, syncs it and renames it over path,
+// so a crash mid-write never leaves a truncated file behind. It returns the
+// number of bytes written, after compression. Saves run one at a time, each
+// taking its snapshot after the previous one finished.
+func saveState(path string) (int, error) {
+	saveMutex.Lock()
+	defer saveMutex.Unlock()
+
+	stateDirty.Store(false)
+	data, err := json.Marshal(snapshotState())
+	if err == nil && compressState(path) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err = zw.Write(data); err == nil {
+			err = zw.Close()
+		}
+		data = buf.Bytes()
+	}
+	if err != nil {
+		markDirty()
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		markDirty()
+		return 0, err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		markDirty()
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// loadState restores state saved by saveState. A gzipped file is detected
+// by its header and decompressed whatever -state-compress says, so turning
+// compression on or off keeps the existing state. A missing file is not an
+// error; the server simply starts empty.
+func loadState(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("No state file at %s, starting empty", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err == nil {
+			data, err = io.ReadAll(zr)
+		}
+		if err != nil {
+			return fmt.Errorf("decompress %s: %w", path, err)
+		}
+	}
+
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	mutex.Lock()
+	devices.update(func(m map[string]DeviceState) {
+		for _, d := range state.Devices {
+			d.DeviceState.TTL = d.TTL
+			m[d.ID] = d.DeviceState
+		}
+	})
+	mutex.Unlock()
+
+	gpsMutex.Lock()
+	gpsLocations.update(func(m map[string]GPSLocation) {
+		for _, l := range state.Locations {
+			l.GPSLocation.TTL = l.TTL
+			m[l.ID] = l.GPSLocation
+			// Only the chosen fix is saved; it seeds its source's stale
+			// guard, so a late retry cannot move the tracker back.
+			sourceFixes[l.ID] = map[string]GPSLocation{l.Source: l.GPSLocation}
+		}
+	})
+	for id, stored := range state.Tracks {
+		track := make([]GPSLocation, len(stored))
+		for i, l := range stored {
+			l.GPSLocation.TTL = l.TTL
+			track[i] = l.GPSLocation
+		}
+		tracks[id] = track
+	}
+	gpsMutex.Unlock()
+
+	log.Printf("Restored %d device(s) and %d tracker(s) from %s (saved %s)",
+		len(state.Devices), len(state.Locations), path, state.SavedAt.Format(time.RFC3339))
+	return nil
+}
+
+// startState applies -on-restart to path and logs the outcome, so operators
+// can see at startup whether state was retained or dropped.
+func startState(path string) error {
+	if *onRestart == "restore" {
+		return loadState(path)
+	}
+	if !*truncateState {
+		log.Printf("Starting with empty state (-on-restart=clear); %s is ignored and will be overwritten on the next save", path)
+		return nil
+	}
+	if _, err := saveState(path); err != nil {
+		return err
+	}
+	log.Printf("Starting with empty state (-on-restart=clear); truncated %s", path)
+	return nil
+}
+
+// persistLoop writes the state to path every interval when it has changed.
+// Once stop is closed it performs a final write and returns, so a clean exit
+// never loses the changes made since the last tick.
+func persistLoop(stop <-chan struct{}, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !stateDirty.Load() {
+				continue
+			}
+			if _, err := saveState(path); err != nil {
+				errorf("Persisting state failed: %v", err)
+			}
+		case <-stop:
+			n, err := saveState(path)
+			if err != nil {
+				errorf("Final state write failed: %v", err)
+				return
+			}
+			log.Printf("Wrote final state to %s (%d bytes)", path, n)
+			return
+		}
+	}
+}
+
+// persistHandler writes the state immediately, for operators about to
+// restart who do not want to wait for the next -persist-interval tick.
+func persistHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return newAPIError(http.StatusMethodNotAllowed, codeBadMethod, "Use POST")
+	}
+	if *stateFile == "" {
+		return newAPIError(http.StatusConflict, codePersistDisabled, "Persistence is disabled; start the server with -state-file")
+	}
+	n, err := saveState(*stateFile)
+	if err != nil {
+		return fmt.Errorf("persist state: %w", err)
+	}
+	log.Printf("Persisted state to %s on request (%d bytes)", *stateFile, n)
+	return writeJSON(w, r, map[string]any{"path": *stateFile, "bytes": n})
+}
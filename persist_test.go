@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Saves racing each other and concurrent writes must each leave a complete
+// state file and no temporary files, and the last save must hold every
+// write made before it.
+func TestConcurrentSaves(t *testing.T) {
+	for _, name := range []string{"state.json", "state.json.gz"} {
+		t.Run(name, func(t *testing.T) {
+			resetState(t)
+			path := filepath.Join(t.TempDir(), name)
+
+			var wg sync.WaitGroup
+			for w := range 4 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := range 50 {
+						dev := DeviceState{ID: fmt.Sprintf("dev-%d-%d", w, i), Value: true, UpdatedAt: time.Now()}
+						if err := recordDevice(context.Background(), dev); err != nil {
+							t.Error(err)
+						}
+					}
+				}()
+			}
+			for range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range 10 {
+						if _, err := saveState(path); err != nil {
+							t.Error(err)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			if _, err := saveState(path); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := os.ReadDir(filepath.Dir(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("state directory holds %d files, want only %s", len(entries), name)
+			}
+
+			resetState(t)
+			if err := loadState(path); err != nil {
+				t.Fatal(err)
+			}
+			if n := devices.len(); n != 200 {
+				t.Errorf("loaded %d devices, want 200", n)
+			}
+		})
+	}
+}
+
+// After a restart a source's late retry, older than its restored fix, must
+// be refused like it would have been before.
+func TestRestoredFixGuardsAgainstOlderRetry(t *testing.T) {
+	for _, source := range []string{"", "gateway"} {
+		resetState(t)
+		path := filepath.Join(t.TempDir(), "state.json")
+		newer := time.Date(2024, 5, 1, 10, 5, 0, 0, time.UTC)
+		if err := recordGPS(context.Background(), GPSLocation{ID: "trk", Lat: 2, Lon: 2, Source: source, UpdatedAt: newer}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := saveState(path); err != nil {
+			t.Fatal(err)
+		}
+
+		resetState(t)
+		if err := loadState(path); err != nil {
+			t.Fatal(err)
+		}
+		err := recordGPS(context.Background(), GPSLocation{ID: "trk", Lat: 1, Lon: 1, Source: source, UpdatedAt: newer.Add(-time.Minute)})
+		var stale *staleError
+		if !errors.As(err, &stale) {
+			t.Errorf("source %q: older retry after restart returned %v, want a stale error", source, err)
+		}
+		if loc, _ := gpsLocations.get("trk"); loc.Lat != 2 {
+			t.Errorf("source %q: retry moved the restored position to %v", source, loc.Lat)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	_ "net/http/pprof"
+	"strings"
+)
+
+var pprofEnabled = flag.Bool("pprof", false, "serve net/http/pprof profiles under /debug/pprof/ (admin only)")
+
+// withPprof gates the /debug/pprof/ handlers, which net/http/pprof registers
+// on the default mux as soon as it is imported. They are hidden unless
+// -pprof is set, and then still require the admin key.
+func withPprof(next http.Handler) http.Handler {
+	admin := requireAdmin(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/debug/pprof/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !*pprofEnabled {
+			writeError(w, newAPIError(http.StatusNotFound, codeNotFound, "Not found"))
+			return
+		}
+		admin.ServeHTTP(w, r)
+	})
+}
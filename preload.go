@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Asset references in a page, by the preload destination they need. A
+// regexp is enough for pages we write ourselves.
+var (
+	scriptSrc = regexp.MustCompile(`<script\b[^>]*\bsrc="([^"]+)"`)
+	styleHref = regexp.MustCompile(`<link\b[^>]*\brel="stylesheet"[^>]*\bhref="([^"]+)"|<link\b[^>]*\bhref="([^"]+)"[^>]*\brel="stylesheet"`)
+)
+
+// preloadLinks returns a Link header value preloading the same-origin
+// scripts and stylesheets page references, so the browser requests them
+// while it is still parsing the page. It is empty for a self-contained page.
+func preloadLinks(page []byte) string {
+	var links []string
+	add := func(ref, as string) {
+		if ref == "" || strings.Contains(ref, "://") || strings.HasPrefix(ref, "//") || strings.HasPrefix(ref, "data:") {
+			return
+		}
+		if !strings.HasPrefix(ref, "/") {
+			ref = "/" + ref
+		}
+		links = append(links, fmt.Sprintf("<%s>; rel=preload; as=%s", ref, as))
+	}
+	for _, m := range scriptSrc.FindAllSubmatch(page, -1) {
+		add(string(m[1]), "script")
+	}
+	for _, m := range styleHref.FindAllSubmatch(page, -1) {
+		add(string(m[1])+string(m[2]), "style")
+	}
+	return strings.Join(links, ", ")
+}
+
+// withPreload adds the preload Link header to responses for the dashboard
+// page at /. Under -webroot the page is read on each request, so hints
+// follow edits made with -dev.
+func withPreload(page []byte, next http.Handler) http.Handler {
+	static := preloadLinks(page)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" || r.URL.Path == "/index.html" {
+			links := static
+			if *webroot != "" {
+				if data, err := os.ReadFile(filepath.Join(*webroot, "index.html")); err == nil {
+					links = preloadLinks(data)
+				}
+			}
+			if links != "" {
+				w.Header().Set("Link", links)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const codeRateLimited = "rate_limited"
+
+var (
+	rateLimit      = flag.Float64("rate-limit", 0, "requests per second allowed per client IP, or per API key listed in -rate-limits-file (0 is unlimited)")
+	rateBurst      = flag.Int("rate-burst", 10, "requests a client may make at once before -rate-limit applies")
+	rateLimitsFile = flag.String("rate-limits-file", "", `JSON file of per-API-key limits, e.g. {"gateway-key": {"rate": 100, "burst": 200}}`)
+)
+
+// keyLimit is the rate limit of one API key.
+type keyLimit struct {
+	Rate  float64 `json:"rate"` // requests per second; 0 is unlimited
+	Burst int     `json:"burst"`
+}
+
+// keyLimits holds the limits loaded from -rate-limits-file, by API key.
+var keyLimits = map[string]keyLimit{}
+
+func loadRateLimits(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	limits := map[string]keyLimit{}
+	if err := json.Unmarshal(data, &limits); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for key, l := range limits {
+		if l.Rate < 0 || l.Burst < 0 || (l.Rate > 0 && l.Burst == 0) {
+			return fmt.Errorf("key %q: rate must not be negative and burst must be positive", key)
+		}
+	}
+	keyLimits = limits
+	return nil
+}
+
+// bucket is a token bucket: it holds up to burst tokens, refills at the
+// caller's rate and each request takes one.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when the bucket will have refilled completely
+}
+
+var (
+	bucketsMutex sync.Mutex
+	buckets      = make(map[string]*bucket)
+)
+
+// callerLimit returns the bucket key and limit for r: its API key, or the
+// caller name the -auth backend resolved it to, when that has its own
+// limit, otherwise its IP with the default limit.
+func callerLimit(r *http.Request) (string, keyLimit) {
+	if key := requestAPIKey(r); key != "" {
+		if l, ok := keyLimits[key]; ok {
+			return "key:" + key, l
+		}
+		if id, ok := authenticator.Authenticate(r); ok && id.Name != "" {
+			if l, ok := keyLimits[id.Name]; ok {
+				return "name:" + id.Name, l
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host, keyLimit{*rateLimit, *rateBurst}
+}
+
+// take spends a token of the bucket named id. When it is empty it returns
+// false and how long until a token is available.
+func take(id string, l keyLimit, now time.Time) (bool, time.Duration) {
+	bucketsMutex.Lock()
+	defer bucketsMutex.Unlock()
+
+	b, ok := buckets[id]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		buckets[id] = b
+	}
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((float64(l.Burst) - b.tokens) / l.Rate * float64(time.Second)))
+	return true, 0
+}
+
+// sweepBuckets periodically forgets buckets that have refilled, which
+// behave exactly like fresh ones, so one-off callers do not accumulate.
+func sweepBuckets(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		bucketsMutex.Lock()
+		for id, b := range buckets {
+			if now.After(b.full) {
+				delete(buckets, id)
+			}
+		}
+		bucketsMutex.Unlock()
+	}
+}
+
+// withRateLimit answers 429 to callers that exceed their rate limit.
+func withRateLimit(next http.Handler) http.Handler {
+	if *rateLimit == 0 && len(keyLimits) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, l := callerLimit(r)
+		if l.Rate == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := take(id, l, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, newAPIError(http.StatusTooManyRequests, codeRateLimited, "Rate limit exceeded, retry later"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
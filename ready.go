@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+const codeNotReady = "not_ready"
+
+// ready is set once the saved state has been loaded. Until then the
+// listener is up but every request other than /ready and /time is refused,
+// so a write cannot be overwritten by the older saved value and a read
+// cannot see half the state.
+var ready atomic.Bool
+
+// withReadiness answers requests with 503 until ready is set.
+func withReadiness(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() && r.URL.Path != "/ready" && r.URL.Path != "/time" {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, newAPIError(http.StatusServiceUnavailable, codeNotReady, "Loading saved state, retry shortly"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readyHandler reports whether the server accepts requests yet, for load
+// balancers and orchestrators. It needs no key, and successful requests
+// are never logged since it is polled.
+func readyHandler(w http.ResponseWriter, r *http.Request) error {
+	if !ready.Load() {
+		w.Header().Set("Retry-After", "1")
+		return newAPIError(http.StatusServiceUnavailable, codeNotReady, "Loading saved state")
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, r, map[string]bool{"ready": true})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// While a large state file loads, /ready and writes answer 503; once
+// /ready answers 200 every saved device is there.
+func TestReadyWaitsForStateLoad(t *testing.T) {
+	const saved = 100000
+	resetState(t)
+	devices.update(func(m map[string]DeviceState) {
+		for i := range saved {
+			id := fmt.Sprintf("dev-%d", i)
+			m[id] = DeviceState{ID: id, Value: true, UpdatedAt: time.Now()}
+		}
+	})
+	path := filepath.Join(t.TempDir(), "state.json")
+	if _, err := saveState(path); err != nil {
+		t.Fatal(err)
+	}
+	resetState(t)
+	ready.Store(false)
+	t.Cleanup(func() { ready.Store(true) })
+
+	mux := http.NewServeMux()
+	mux.Handle("/ready", apiHandler(readyHandler))
+	mux.Handle("/update", apiHandler(updateHandler))
+	h := withReadiness(mux)
+
+	if w := serve(h, http.MethodGet, "/ready", nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("/ready before loading: %d, want 503", w.Code)
+	}
+	if w := serve(h, http.MethodGet, "/update?id=fresh&value=true", nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("/update before loading: %d, want 503", w.Code)
+	}
+
+	loaded := make(chan error, 1)
+	go func() {
+		err := loadState(path)
+		ready.Store(true)
+		loaded <- err
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		w := serve(h, http.MethodGet, "/ready", nil)
+		if w.Code == http.StatusOK {
+			if n := devices.len(); n != saved {
+				t.Fatalf("/ready answered 200 with %d of %d devices loaded", n, saved)
+			}
+			break
+		}
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("/ready while loading: %d", w.Code)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("state did not finish loading within 10s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := <-loaded; err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(h, http.MethodGet, "/update?id=fresh&value=true", nil); w.Code != http.StatusOK {
+		t.Errorf("/update after loading: %d %s", w.Code, w.Body)
+	}
+	if n := devices.len(); n != saved+1 {
+		t.Errorf("%d devices after the first write, want %d", n, saved+1)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	reconnectBase   = flag.Duration("reconnect-base", time.Second, "first reconnection delay advertised to SSE clients")
+	reconnectMax    = flag.Duration("reconnect-max", 30*time.Second, "largest reconnection delay advertised to SSE clients")
+	reconnectJitter = flag.Float64("reconnect-jitter", 0.2, "fraction of random jitter SSE clients should add to each reconnection delay")
+)
+
+// advertiseReconnect tells an SSE client how to back off after losing the
+// connection, so every dashboard follows the same policy after a restart.
+// Browsers only understand the retry field, which sets their fixed delay;
+// other clients read the X-Reconnect-Backoff header and back off
+// exponentially from base to max. It must be called before anything is
+// written.
+func advertiseReconnect(w http.ResponseWriter) {
+	w.Header().Set("X-Reconnect-Backoff", fmt.Sprintf("base=%d; max=%d; jitter=%g",
+		reconnectBase.Milliseconds(), reconnectMax.Milliseconds(), *reconnectJitter))
+	fmt.Fprintf(w, "retry: %d\n\n", reconnectBase.Milliseconds())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var eventIDs = flag.String("event-ids", "instance", "SSE event ids: instance (<server instance>-<seq>, so a Last-Event-ID from before a restart is recognized) or seq (the bare sequence number)")
+
+// What a connecting SSE client is sent before live events.
+const (
+	noReplay     = iota
+	replayAfter  // the buffered events after its Last-Event-ID
+	replayResync // a resync event, then every buffered event
+)
+
+// sseEventID is the SSE id of the stored event seq.
+func sseEventID(seq uint64) string {
+	if *eventIDs == "seq" {
+		return strconv.FormatUint(seq, 10)
+	}
+	return instanceID + "-" + strconv.FormatUint(seq, 10)
+}
+
+// lastEventID returns the sequence number a reconnecting SSE client last
+// received, from the Last-Event-ID header browsers send automatically or
+// from ?last_event_id= for a first connection, and how to replay. An id from
+// another server instance means the client's sequence numbers are
+// meaningless here, so it must resync. Bare sequence numbers are always
+// accepted.
+func lastEventID(r *http.Request) (uint64, int, error) {
+	s := r.Header.Get("Last-Event-ID")
+	if q := r.URL.Query().Get("last_event_id"); q != "" {
+		s = q
+	}
+	if s == "" {
+		return 0, noReplay, nil
+	}
+	instance, seq, found := strings.Cut(s, "-")
+	if !found {
+		seq = instance
+	}
+	id, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, noReplay, invalidParam("last_event_id")
+	}
+	if found && instance != instanceID {
+		return 0, replayResync, nil
+	}
+	return id, replayAfter, nil
+}
+
+// writeResync tells a client whose Last-Event-ID predates a restart to drop
+// what it has; the replay of the whole buffer that follows rebuilds it.
+func writeResync(w http.ResponseWriter, rc *http.ResponseController, c *client) error {
+	return writeControlEvent(w, rc, c, SSEMessage{
+		Type:    "resync",
+		Message: "The server restarted since the last event received; discard local state",
+		Channel: channelSystem,
+		Time:    time.Now(),
+	})
+}
+
+// writeReplay sends c the buffered events after lastID. The boundary is
+// exclusive, as for /history?since=: lastID is the last event the client
+// has, so replay starts at lastID+1. It returns the newest sequence number
+// covered, so live events up to it can be skipped. When events after lastID
+// have already left the buffer, a replay-gap event says so first.
+func writeReplay(w http.ResponseWriter, rc *http.ResponseController, c *client, lastID uint64) (uint64, error) {
+	historyMutex.Lock()
+	var msgs []SSEMessage
+	for _, msg := range history {
+		if msg.Seq > lastID {
+			msgs = append(msgs, msg)
+		}
+	}
+	upTo := lastSeq
+	historyMutex.Unlock()
+
+	// The first event the client lacks is lastID+1; anything between it
+	// and the oldest buffered event is lost.
+	oldest := upTo + 1
+	if len(msgs) > 0 {
+		oldest = msgs[0].Seq
+	}
+	if oldest > lastID+1 {
+		gap := SSEMessage{
+			Type:    "replay-gap",
+			Message: fmt.Sprintf("Events %d to %d are no longer buffered", lastID+1, oldest-1),
+			Channel: channelSystem,
+			Time:    time.Now(),
+		}
+		if err := writeControlEvent(w, rc, c, gap); err != nil {
+			return 0, err
+		}
+	}
+	return upTo, writeEvents(w, rc, c, msgs)
+}
+
+// writeEvents sends msgs to c, filtered and encoded as the broker would for
+// live events, then flushes.
+func writeEvents(w http.ResponseWriter, rc *http.ResponseController, c *client, msgs []SSEMessage) error {
+	extendWriteDeadline(rc)
+	enc := encoding{c.profile, c.cloudEvents}
+	for _, msg := range msgs {
+		if !c.wants(msg) {
+			continue
+		}
+		event, _ := json.Marshal(msg)
+		data, err := enc.encode(msg, renameEvent(event))
+		if err != nil {
+			return err
+		}
+		if err := writeEvent(w, encodedEvent{seq: msg.Seq, data: data}); err != nil {
+			return err
+		}
+	}
+	return rc.Flush()
+}
+
+// writeEvent writes one SSE event. Stored events carry their sequence number
+// in the SSE id, which browsers echo back as Last-Event-ID on reconnect.
+func writeEvent(w http.ResponseWriter, ev encodedEvent) error {
+	var err error
+	if ev.seq != 0 {
+		_, err = fmt.Fprintf(w, "id: %s\ndata: %s\n\n", sseEventID(ev.seq), ev.data)
+	} else {
+		_, err = fmt.Fprintf(w, "data: %s\n\n", ev.data)
+	}
+	return err
+}
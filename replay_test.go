@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replayStream connects to /events with a Last-Event-ID and returns the
+// events received, decoded.
+func replayStream(t *testing.T, lastEventID string) <-chan SSEMessage {
+	t.Helper()
+	srv := httptest.NewServer(broker)
+	t.Cleanup(srv.Close)
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", lastEventID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Last-Event-ID %q: status %d", lastEventID, resp.StatusCode)
+	}
+
+	events := make(chan SSEMessage, 64)
+	go func() {
+		defer close(events)
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+				var msg SSEMessage
+				json.Unmarshal([]byte(data), &msg)
+				events <- msg
+			}
+		}
+	}()
+	return events
+}
+
+// seedHistory restarts the sequence as a fresh instance would and
+// broadcasts n notes, returning the sequence number of the first.
+func seedHistory(t *testing.T, n int) uint64 {
+	t.Helper()
+	resetState(t)
+	historyMutex.Lock()
+	lastSeq = 0
+	historyMutex.Unlock()
+	for range n {
+		broadcast("note", "seeded")
+	}
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	return history[0].Seq
+}
+
+// replayed reads events from a stream opened with lastEventID until the
+// marker note broadcast after it connects, returning each one as its type,
+// followed by its sequence number for stored events.
+func replayed(t *testing.T, lastEventID string) []string {
+	t.Helper()
+	events := replayStream(t, lastEventID)
+	waitForClients(t, 1)
+	broadcast("note", "marker")
+	var got []string
+	for {
+		var msg SSEMessage
+		select {
+		case m, ok := <-events:
+			if !ok {
+				t.Fatal("stream closed")
+			}
+			msg = m
+		case <-time.After(time.Second):
+			t.Fatalf("no marker within 1s, got %v", got)
+		}
+		if msg.Message == "marker" {
+			return got
+		}
+		if msg.Seq != 0 {
+			got = append(got, fmt.Sprintf("%s %d", msg.Type, msg.Seq))
+		} else {
+			got = append(got, msg.Type)
+		}
+	}
+}
+
+// A Last-Event-ID stamped by another instance refers to that instance's
+// sequence, so replaying after its number would skip events; the client
+// must be told to resync and sent everything buffered instead.
+func TestForeignLastEventIDResyncs(t *testing.T) {
+	first := seedHistory(t, 3)
+	id := "0123456789abcdef-" + strconv.FormatUint(first+1, 10)
+	got := replayed(t, id)
+	want := []string{
+		"resync",
+		fmt.Sprintf("note %d", first),
+		fmt.Sprintf("note %d", first+1),
+		fmt.Sprintf("note %d", first+2),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Last-Event-ID %s: got %v, want %v", id, got, want)
+	}
+}
+
+// Replay is exclusive of the Last-Event-ID, reports events that have left
+// the buffer, and sends nothing for an id past the newest event.
+func TestReplayBoundaries(t *testing.T) {
+	setFlag(t, &maxHistory, 3)
+	for _, tc := range []struct {
+		name string
+		last uint64 // the buffer holds events 3 to 5
+		want []string
+	}{
+		{"before oldest", 2, []string{"note 3", "note 4", "note 5"}},
+		{"oldest", 3, []string{"note 4", "note 5"}},
+		{"evicted", 1, []string{"replay-gap", "note 3", "note 4", "note 5"}},
+		{"newest", 5, nil},
+		{"future", 9, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			oldest := seedHistory(t, 5)
+			if oldest != 3 {
+				t.Fatalf("oldest retained seq is %d, want 3", oldest)
+			}
+			got := replayed(t, sseEventID(tc.last))
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("Last-Event-ID %d: got %v, want %v", tc.last, got, tc.want)
+			}
+		})
+	}
+}
+
+// Control events are renamed and wrapped like broadcasts, so a client
+// reading the stream under -field-map or CloudEvents can parse them.
+func TestResyncUsesClientEncoding(t *testing.T) {
+	resetState(t)
+	quiesce(t, broker)
+	setFlag(t, fieldMap, "type=kind")
+	if err := parseFieldNames(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fieldName = nil })
+
+	srv := httptest.NewServer(broker)
+	t.Cleanup(srv.Close)
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events?format=cloudevents", nil)
+	req.Header.Set("Last-Event-ID", "0123456789abcdef-1")
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev cloudEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			t.Fatalf("event is not a CloudEvent: %s", data)
+		}
+		if ev.Type != cloudEventTypePrefix+"resync" {
+			continue
+		}
+		var payload map[string]any
+		json.Unmarshal(ev.Data, &payload)
+		if payload["kind"] != "resync" || payload["type"] != nil {
+			t.Errorf("resync data = %s, want the type under kind", ev.Data)
+		}
+		return
+	}
+	t.Fatalf("no resync event: %v", sc.Err())
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+var logSampleRate = flag.Float64("log-sample-rate", 0, "fraction (0.0-1.0) of requests to log; failed requests (status >= 400) are always logged")
+
+// statusRecorder captures the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, which the
+// SSE handlers need for flushing and deadlines.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// withRequestLog logs one line per completed request: every failure, and a
+// random -log-sample-rate fraction of the rest except /time and /ready,
+// which are polled. The query string is left out because it may carry an
+// API key. Every request's duration also goes into the /metrics histogram
+// of its route.
+func withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r)
+
+		if sr.Header().Get("Content-Type") != "text/event-stream" {
+			_, pattern := http.DefaultServeMux.Handler(r)
+			observeLatency(pattern, time.Since(start))
+		}
+
+		status := sr.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status < 400 && (r.URL.Path == "/time" || r.URL.Path == "/ready" || *logSampleRate <= 0 || rand.Float64() >= *logSampleRate) {
+			return
+		}
+		log.Printf("%s %s %s %d %s", r.RemoteAddr, r.Method, r.URL.Path, status, time.Since(start).Round(time.Microsecond))
+	})
+}
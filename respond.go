@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	strictFields  = flag.Bool("strict-fields", false, "reject ?fields= selections naming unknown fields with 400")
+	writeResponse = flag.String("write-response", "text", "success response of /update and /gps: text (a short confirmation) or 204 (no body)")
+)
+
+// writeDone confirms a successful write, either with a one-line text body or
+// with 204 No Content for clients that ignore it.
+func writeDone(w http.ResponseWriter, format string, args ...any) {
+	if *writeResponse == "204" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	fmt.Fprintf(w, format, args...)
+}
+
+// writeJSON encodes v as the response body with keys named per -field-names,
+// projecting objects down to the fields listed in the request's ?fields=
+// param when present and indenting the output for ?pretty=true. The body is
+// encoded before anything is sent, so nothing is written when an error is
+// returned and it can still be reported as one.
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	v, err := renameFields(v)
+	if err != nil {
+		return fmt.Errorf("rename fields: %w", err)
+	}
+
+	if fields := r.URL.Query().Get("fields"); fields != "" {
+		projected, unknown, err := project(v, strings.Split(fields, ","))
+		if err != nil {
+			return fmt.Errorf("project fields: %w", err)
+		}
+		if *strictFields && len(unknown) > 0 {
+			return newAPIError(http.StatusBadRequest, codeUnknownField, "Unknown fields: "+strings.Join(unknown, ","))
+		}
+		v = projected
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); pretty {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("encode response: %w", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+	return nil
+}
+
+// project round-trips v through JSON and keeps only the named keys of every
+// object it finds at the top level or inside a top-level array. It also
+// reports which of the requested fields matched no object at all.
+func project(v any, fields []string) (any, []string, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	var generic any
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return nil, nil, err
+	}
+
+	keep := make(map[string]bool)
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f != "" {
+			keep[f] = true
+		}
+	}
+	seen := make(map[string]bool)
+
+	filter := func(obj map[string]any) map[string]any {
+		out := make(map[string]any, len(keep))
+		for k, val := range obj {
+			if keep[k] {
+				out[k] = val
+				seen[k] = true
+			}
+		}
+		return out
+	}
+
+	switch t := generic.(type) {
+	case map[string]any:
+		generic = filter(t)
+	case []any:
+		for i, item := range t {
+			if obj, ok := item.(map[string]any); ok {
+				t[i] = filter(obj)
+			}
+		}
+	}
+
+	var unknown []string
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f != "" && !seen[f] {
+			unknown = append(unknown, f)
+		}
+	}
+	return generic, unknown, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+)
+
+var routeMatching = flag.String("route-matching", "strict", "how near-miss paths such as /Update or /update/ are handled: strict (404) or lenient (routed, ignoring case and a trailing slash)")
+
+// notFoundRoute answers requests for paths that no endpoint or page serves,
+// instead of falling through to the dashboard.
+func notFoundRoute(w http.ResponseWriter, r *http.Request) {
+	writeError(w, newAPIError(http.StatusNotFound, codeNotFound, "No such endpoint: "+r.URL.Path))
+}
+
+// dashboardOnly restricts the embedded dashboard to / and /index.html; the
+// "/" pattern would otherwise serve it for every unknown path.
+func dashboardOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" && r.URL.Path != "/index.html" {
+			notFoundRoute(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withLenientRoutes routes a path that only the catch-all matches to the
+// endpoint it nearly names, ignoring case and a trailing slash. Patterns
+// with wildcards such as /entity/{id} are left alone, since lowercasing
+// would change the id. It is a no-op with -route-matching=strict.
+func withLenientRoutes(mux *http.ServeMux) http.Handler {
+	if *routeMatching != "lenient" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern == "/" && r.URL.Path != "/" {
+			path := strings.ToLower(strings.TrimSuffix(r.URL.Path, "/"))
+			r2 := r.Clone(r.Context())
+			r2.URL.Path, r2.URL.RawPath = path, ""
+			if _, p := mux.Handler(r2); p != "/" && !strings.Contains(p, "{") {
+				r = r2
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+// routeMux stands in for the server's mux: each handler answers with its
+// own name, and / serves the dashboard through dashboardOnly.
+func routeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	named := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, name) }
+	}
+	mux.Handle("/", dashboardOnly(named("dashboard")))
+	mux.Handle("/update", named("update"))
+	mux.Handle("/devices", named("devices"))
+	mux.HandleFunc("/entity/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "entity "+r.PathValue("id"))
+	})
+	return mux
+}
+
+func TestRouteMatching(t *testing.T) {
+	for _, tc := range []struct {
+		path    string
+		strict  string // body served, empty for a 404
+		lenient string
+	}{
+		{"/", "dashboard", "dashboard"},
+		{"/index.html", "dashboard", "dashboard"},
+		{"/update", "update", "update"},
+		{"/update/", "", "update"},
+		{"/Update", "", "update"},
+		{"/UPDATE/", "", "update"},
+		{"/Devices", "", "devices"},
+		{"/entity/Van-3", "entity Van-3", "entity Van-3"},
+		{"/Entity/van-3", "", ""},
+		{"/updates", "", ""},
+		{"/nope", "", ""},
+		{"/INDEX.HTML", "", ""},
+	} {
+		for mode, want := range map[string]string{"strict": tc.strict, "lenient": tc.lenient} {
+			setFlag(t, routeMatching, mode)
+			w := serve(withLenientRoutes(routeMux()), http.MethodGet, tc.path, nil)
+			switch {
+			case want == "" && w.Code != http.StatusNotFound:
+				t.Errorf("%s %s: status %d (%q), want 404", mode, tc.path, w.Code, w.Body)
+			case want != "" && w.Body.String() != want:
+				t.Errorf("%s %s: status %d, body %q, want %q", mode, tc.path, w.Code, w.Body, want)
+			}
+		}
+	}
+}
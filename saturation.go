@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	saturationThreshold = flag.Float64("saturation-threshold", 0, "fraction (0.0-1.0) of the notifier queue or a client buffer whose sustained use raises a broker-saturated warning (0 disables)")
+	saturationWindow    = flag.Duration("saturation-window", 10*time.Second, "how long occupancy must stay above -saturation-threshold before the warning is raised")
+)
+
+// saturationSample is how often the saturation watchdog samples the broker.
+const saturationSample = time.Second
+
+// saturated is set while the broker is over -saturation-threshold.
+var saturated atomic.Bool
+
+// brokerOccupancy returns how full the notifier queue is and how full the
+// fullest client buffer is, each as a fraction of its capacity. It does
+// not go through the broker's loop, so it answers while that loop is the
+// thing falling behind.
+func brokerOccupancy() (notifier, clients float64) {
+	notifier = float64(len(broker.Notifier)) / float64(cap(broker.Notifier))
+	for _, c := range broker.subscribers() {
+		clients = max(clients, float64(len(c.ch))/float64(cap(c.ch)))
+	}
+	return notifier, clients
+}
+
+// watchSaturation warns once the notifier queue or a client buffer has been
+// above threshold for every sample over window, and again when the broker
+// recovers. Drops start when a buffer is full, so this is raised before
+// they become widespread.
+func watchSaturation(threshold float64, window time.Duration) {
+	var since time.Time // first sample of the current run above threshold
+	for range time.Tick(saturationSample) {
+		notifier, clients := brokerOccupancy()
+		if notifier < threshold && clients < threshold {
+			since = time.Time{}
+			if saturated.CompareAndSwap(true, false) {
+				announceSaturation("broker-recovered", "Broker recovered: queues back below the saturation threshold")
+			}
+			continue
+		}
+		if since.IsZero() {
+			since = time.Now()
+		}
+		if time.Since(since) >= window && saturated.CompareAndSwap(false, true) {
+			announceSaturation("broker-saturated", fmt.Sprintf("Broker saturated for %s: notifier queue %.0f%% full, fullest client buffer %.0f%% full",
+				window, notifier*100, clients*100))
+		}
+	}
+}
+
+// announceSaturation logs a saturation change and tells live clients on
+// the system channel. The event is not kept in history and is dropped if
+// the notifier queue is full, so it adds no load to a saturated broker.
+func announceSaturation(event, message string) {
+	if event == "broker-saturated" {
+		warnf("%s", message)
+	} else {
+		log.Println(message)
+	}
+	tryNotify(SSEMessage{Type: "system", Event: event, Message: message, Channel: channelSystem, Time: time.Now()})
+}
+
+// writeSaturationMetrics renders the broker occupancy gauges for /metrics.
+func writeSaturationMetrics(w io.Writer) {
+	notifier, clients := brokerOccupancy()
+	var value int
+	if saturated.Load() {
+		value = 1
+	}
+	fmt.Fprintln(w, "# HELP broker_notifier_occupancy Fraction of the broker's notifier queue in use.")
+	fmt.Fprintln(w, "# TYPE broker_notifier_occupancy gauge")
+	fmt.Fprintf(w, "broker_notifier_occupancy %g\n", notifier)
+	fmt.Fprintln(w, "# HELP broker_client_buffer_occupancy Fraction in use of the fullest SSE client buffer.")
+	fmt.Fprintln(w, "# TYPE broker_client_buffer_occupancy gauge")
+	fmt.Fprintf(w, "broker_client_buffer_occupancy %g\n", clients)
+	fmt.Fprintln(w, "# HELP broker_saturated Whether the broker has been above -saturation-threshold for -saturation-window.")
+	fmt.Fprintln(w, "# TYPE broker_saturated gauge")
+	fmt.Fprintf(w, "broker_saturated %d\n", value)
+}
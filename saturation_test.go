@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// stallBroker replaces the broker with one whose fan-out loop never runs,
+// its notifier queue and one subscriber's buffer filled to capacity.
+func stallBroker(t *testing.T) {
+	t.Helper()
+	old := broker
+	stalled := &Broker{Notifier: make(chan SSEMessage, notifierQueue)}
+	for len(stalled.Notifier) < cap(stalled.Notifier) {
+		stalled.Notifier <- SSEMessage{Type: "note"}
+	}
+	c := &client{ch: make(chan encodedEvent, clientBuffer), started: time.Now()}
+	for len(c.ch) < cap(c.ch) {
+		c.ch <- encodedEvent{}
+	}
+	stalled.published.Store(&[]*client{c})
+	broker = stalled
+	t.Cleanup(func() { broker = old })
+}
+
+func TestOccupancyWhileFanoutIsStuck(t *testing.T) {
+	stallBroker(t)
+
+	done := make(chan [2]float64)
+	go func() {
+		notifier, clients := brokerOccupancy()
+		done <- [2]float64{notifier, clients}
+	}()
+	select {
+	case got := <-done:
+		if got != [2]float64{1, 1} {
+			t.Errorf("occupancy = %v, want notifier and client buffer both full", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("brokerOccupancy blocked on a stuck broker")
+	}
+}
+
+func TestPartialNotifierOccupancy(t *testing.T) {
+	stallBroker(t)
+	for range notifierQueue / 4 {
+		<-broker.Notifier
+	}
+	if notifier, _ := brokerOccupancy(); notifier != 0.75 {
+		t.Errorf("notifier occupancy = %g, want 0.75", notifier)
+	}
+}
+
+func TestSaturationWarningSkipsHistory(t *testing.T) {
+	resetState(t)
+	stallBroker(t)
+
+	done := make(chan struct{})
+	go func() {
+		announceSaturation("broker-saturated", "saturated")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("announceSaturation blocked on a full notifier queue")
+	}
+
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if len(history) != 0 {
+		t.Errorf("history has %d events, want the warning kept out of it", len(history))
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var shutdownTimeout = flag.Duration("shutdown-timeout", 5*time.Second, "how long shutdown waits for requests to finish before force-closing the remaining connections")
+
+// openConns counts the HTTP connections that are not yet closed.
+var openConns atomic.Int64
+
+// trackConn is an http.Server ConnState hook maintaining openConns.
+func trackConn(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		openConns.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		openConns.Add(-1)
+	}
+}
+
+// workers tracks the goroutines that change the persisted state on their
+// own, such as simulations, GPX replays and the sweepers. They run until
+// ctx is cancelled.
+type workers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newWorkers() *workers {
+	w := &workers{}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	return w
+}
+
+// Go runs fn in a goroutine that stop waits for.
+func (w *workers) Go(fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(w.ctx)
+	}()
+}
+
+// stop cancels the workers and waits for them to return, so nothing marks
+// the state dirty after the final write.
+func (w *workers) stop() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// background holds the server's workers.
+var background = newWorkers()
+
+// flushOnShutdown stops the workers, then has the persistence loop behind
+// persistStop and persistDone, if any, write the final state. Stopping the
+// workers first means the file holds the last change they made.
+func flushOnShutdown(persistStop, persistDone chan struct{}) {
+	background.stop()
+	if persistStop != nil {
+		close(persistStop)
+		<-persistDone
+	}
+}
+
+// shutdownHTTP stops srv gracefully and, once -shutdown-timeout passes,
+// force-closes whatever is still open. SSE streams never finish on their
+// own, so without the fallback they would hold up the exit.
+func shutdownHTTP(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err == nil {
+		return
+	}
+	warnf("Shutdown grace period of %s passed, force-closing %d connection(s)", *shutdownTimeout, openConns.Load())
+	if err := srv.Close(); err != nil {
+		errorf("HTTP close: %v", err)
+	}
+}
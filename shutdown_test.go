@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The final write on shutdown must come after the workers stop, so the
+// file holds the last fix of a running simulation and nothing changes the
+// state once it is written.
+func TestFlushOnShutdown(t *testing.T) {
+	resetState(t)
+	setFlag(t, &background, newWorkers())
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		persistLoop(stop, path, time.Hour)
+	}()
+	background.Go(func(ctx context.Context) { sweepExpired(ctx, 10*time.Millisecond) })
+
+	route := `[{"lat":0,"lon":0},{"lat":1,"lon":1}]`
+	w := serve(apiHandler(simulateRouteHandler), http.MethodPost, "/simulate/route?id=sim&speed=10&tick=10ms", strings.NewReader(route))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("/simulate/route: %d %s", w.Code, w.Body)
+	}
+	deadline := time.Now().Add(time.Second)
+	for gpsTrackLen("sim") < 5 {
+		if time.Now().After(deadline) {
+			t.Fatal("simulation recorded no fixes")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	flushOnShutdown(stop, done)
+	last, _ := gpsLocations.get("sim")
+	time.Sleep(50 * time.Millisecond)
+	if loc, _ := gpsLocations.get("sim"); loc != last {
+		t.Errorf("simulation moved the tracker after shutdown: %+v, then %+v", last, loc)
+	}
+	if stateDirty.Load() {
+		t.Error("state changed after the final write")
+	}
+
+	resetState(t)
+	if err := loadState(path); err != nil {
+		t.Fatal(err)
+	}
+	if loc, _ := gpsLocations.get("sim"); loc.Lat != last.Lat || loc.Lon != last.Lon {
+		t.Errorf("state file holds %v,%v, want the last fix %v,%v", loc.Lat, loc.Lon, last.Lat, last.Lon)
+	}
+}
+
+// gpsTrackLen returns how many fixes id's track holds.
+func gpsTrackLen(id string) int {
+	gpsMutex.Lock()
+	defer gpsMutex.Unlock()
+	return len(tracks[id])
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// useAPIKey switches the static authenticator to key for the test.
+func useAPIKey(t *testing.T, key string) {
+	t.Helper()
+	setFlag(t, apiKey, key)
+	if err := loadAuthenticator(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { loadAuthenticator() })
+}
+
+// With -protect-reads, a keyless /events client must reach the challenge
+// rather than be refused before it.
+func TestProtectedEventsOfferChallenge(t *testing.T) {
+	useAPIKey(t, "k")
+	setFlag(t, protectReads, true)
+	setFlag(t, sseAuthChallenge, true)
+	setFlag(t, signingKey, "secret")
+	h := requireStreamRead(broker)
+
+	resp, lines := openSSE(t, h, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("keyless /events: status %d, want 200", resp.StatusCode)
+	}
+	if line := nextEvent(t, lines); !strings.Contains(line, `"type":"auth-challenge"`) {
+		t.Errorf("first event %s, want an auth-challenge", line)
+	}
+
+	if resp, _ := openSSE(t, h, "expires=9999999999&sig=forged"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("forged signature: status %d, want 403", resp.StatusCode)
+	}
+
+	// Without the challenge, -protect-reads refuses keyless clients.
+	setFlag(t, sseAuthChallenge, false)
+	if resp, _ := openSSE(t, requireStreamRead(broker), ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("keyless /events without challenge: status %d, want 401", resp.StatusCode)
+	}
+}
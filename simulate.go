@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var simulateTick = flag.Duration("simulate-tick", time.Second, "default interval between the fixes of a simulated route")
+
+// maxWaypoints caps the length of a simulated route.
+const maxWaypoints = 10000
+
+// waypoint is one corner of a simulated route.
+type waypoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+var (
+	simMutex    sync.Mutex
+	simulations = make(map[string]*simulation) // running routes by tracker id
+)
+
+// simulation is a running route; cancel stops it.
+type simulation struct {
+	cancel context.CancelFunc
+}
+
+// simulateRouteHandler starts moving tracker id along the JSON array of
+// waypoints in the body at ?speed= (in -speed-units), recording a fix every
+// ?tick= (default -simulate-tick) through the normal GPS path. A route
+// already running for id is replaced.
+func simulateRouteHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return newAPIError(http.StatusMethodNotAllowed, codeBadMethod, "Use POST")
+	}
+	q := r.URL.Query()
+	id := q.Get("id")
+	if id == "" {
+		return missingParam("id")
+	}
+	if err := checkTrackerID(id); err != nil {
+		return err
+	}
+	speedStr := q.Get("speed")
+	if speedStr == "" {
+		return missingParam("speed")
+	}
+	speed, err := strconv.ParseFloat(speedStr, 64)
+	if err != nil || speed <= 0 {
+		return invalidParam("speed")
+	}
+	tick := *simulateTick
+	if s := q.Get("tick"); s != "" {
+		if tick, err = time.ParseDuration(s); err != nil || tick < 10*time.Millisecond {
+			return invalidParam("tick")
+		}
+	}
+
+	var route []waypoint
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWaypoints*64)).Decode(&route); err != nil {
+		return newAPIError(http.StatusBadRequest, codeInvalidParam, "Malformed waypoints: "+err.Error())
+	}
+	if len(route) < 2 {
+		return newAPIError(http.StatusBadRequest, codeInvalidParam, "A route needs at least two waypoints")
+	}
+	if len(route) > maxWaypoints {
+		return newAPIError(http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("A route has at most %d waypoints", maxWaypoints))
+	}
+	var length float64
+	for i, p := range route {
+		if !validCoordinate(p.Lat, 90) || !validCoordinate(p.Lon, 180) {
+			return newAPIError(http.StatusBadRequest, codeInvalidParam, fmt.Sprintf("Waypoint %d is out of range", i+1))
+		}
+		if i > 0 {
+			length += distance(route[i-1].location(), p.location())
+		}
+	}
+
+	ctx, cancel := context.WithCancel(background.ctx)
+	sim := &simulation{cancel: cancel}
+	simMutex.Lock()
+	if old, ok := simulations[id]; ok {
+		old.cancel()
+	}
+	simulations[id] = sim
+	simMutex.Unlock()
+
+	step := speed / metersPerSecond[*speedUnits] * tick.Seconds()
+	background.Go(func(context.Context) { runSimulation(ctx, id, sim, route, step, tick) })
+
+	eta := time.Duration(length / step * float64(tick)).Round(time.Second)
+	log.Printf("Simulating %s along %d waypoint(s), %.0f m at %g %s", id, len(route), length, speed, *speedUnits)
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Simulating %s along %.0f m, about %s\n", id, length, eta)
+	return nil
+}
+
+// simulateStopHandler cancels the route running for ?id=.
+func simulateStopHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return newAPIError(http.StatusMethodNotAllowed, codeBadMethod, "Use POST")
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return missingParam("id")
+	}
+	simMutex.Lock()
+	sim, ok := simulations[id]
+	if ok {
+		sim.cancel()
+		delete(simulations, id)
+	}
+	simMutex.Unlock()
+	if !ok {
+		return newAPIError(http.StatusNotFound, codeNotFound, "No route is running for "+id)
+	}
+	log.Printf("Simulated route for %s stopped", id)
+	writeDone(w, "Stopped route for %s\n", id)
+	return nil
+}
+
+// runSimulation records a fix every tick, step meters further along route,
+// until the last waypoint is reached or ctx is cancelled.
+func runSimulation(ctx context.Context, id string, sim *simulation, route []waypoint, step float64, tick time.Duration) {
+	defer func() {
+		simMutex.Lock()
+		if simulations[id] == sim {
+			delete(simulations, id)
+		}
+		simMutex.Unlock()
+		sim.cancel()
+	}()
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	last := len(route) - 2 // index of the final segment
+	seg, along := 0, 0.0   // current segment and meters travelled into it
+	for {
+		a, b := route[seg].location(), route[seg+1].location()
+		segLen := distance(a, b)
+		final := seg == last && along >= segLen
+		loc := b
+		if along < segLen {
+			f := along / segLen
+			loc.Lat, loc.Lon = a.Lat+(b.Lat-a.Lat)*f, a.Lon+(b.Lon-a.Lon)*f
+		}
+		loc.ID, loc.UpdatedAt = id, time.Now()
+		if err := recordGPS(ctx, loc); err != nil {
+			warnf("Simulated route for %s stopped: %v", id, err)
+			return
+		}
+		if final {
+			log.Printf("Simulated route for %s finished", id)
+			return
+		}
+
+		along += step
+		for seg < last && along >= segLen {
+			along -= segLen
+			seg++
+			segLen = distance(route[seg].location(), route[seg+1].location())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p waypoint) location() GPSLocation {
+	return GPSLocation{Lat: p.Lat, Lon: p.Lon}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// fullState is the payload of a snapshot event. Both lists are always
+// present, so an empty one means there is nothing of that kind.
+type fullState struct {
+	Devices   []DeviceState `json:"devices"`
+	Locations []GPSLocation `json:"locations"`
+}
+
+var snapshotInterval = flag.Duration("snapshot-interval", 0, "broadcast the full device and GPS state to every SSE client this often, so clients that dropped events can reconcile (0 disables)")
+
+// broadcastSnapshots periodically sends every subscriber a snapshot event of
+// all devices and locations. Snapshots are ephemeral: they bypass history,
+// so they are neither replayed nor dumped, and a client that misses one
+// simply waits for the next.
+func broadcastSnapshots(interval time.Duration) {
+	for range time.Tick(interval) {
+		devs, locs := deviceList(), locationList()
+		notify(SSEMessage{
+			Type:    "snapshot",
+			State:   &fullState{devs, locs},
+			Message: fmt.Sprintf("State of %d device(s) and %d tracker(s)", len(devs), len(locs)),
+			Channel: channelSystem,
+			Time:    time.Now(),
+		})
+	}
+}
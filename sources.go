@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var (
+	gpsSourcePolicy   = flag.String("gps-source-policy", "newest", "which source's fix becomes a tracker's position when several report it: newest or priority")
+	gpsSourcePriority = flag.String("gps-source-priority", "", "comma-separated sources, most authoritative first, for -gps-source-policy=priority")
+)
+
+// sourceRank maps a source to its position in -gps-source-priority.
+var sourceRank map[string]int
+
+// sourceFixes holds the latest fix of every tracker from each source that
+// reports it, keyed by tracker id then source. It is guarded by gpsMutex.
+var sourceFixes = make(map[string]map[string]GPSLocation)
+
+func parseSourcePolicy() error {
+	switch *gpsSourcePolicy {
+	case "newest":
+		return nil
+	case "priority":
+		sourceRank = make(map[string]int)
+		for i, s := range strings.Split(*gpsSourcePriority, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				sourceRank[s] = i
+			}
+		}
+		if len(sourceRank) == 0 {
+			return fmt.Errorf("-gps-source-policy=priority needs -gps-source-priority")
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid -gps-source-policy %q: want newest or priority", *gpsSourcePolicy)
+	}
+}
+
+// rank orders sources for the priority policy; unlisted sources come last.
+func rank(source string) int {
+	if r, ok := sourceRank[source]; ok {
+		return r
+	}
+	return len(sourceRank)
+}
+
+// chooseFix picks the authoritative fix among a tracker's sources. Under
+// the priority policy the best-ranked source wins and the newest fix breaks
+// ties; under newest only the timestamp counts.
+func chooseFix(fixes map[string]GPSLocation) GPSLocation {
+	var best GPSLocation
+	first := true
+	for _, fix := range fixes {
+		switch {
+		case first:
+		case *gpsSourcePolicy == "priority" && rank(fix.Source) != rank(best.Source):
+			if rank(fix.Source) > rank(best.Source) {
+				continue
+			}
+		case !fix.UpdatedAt.After(best.UpdatedAt):
+			continue
+		}
+		best, first = fix, false
+	}
+	return best
+}
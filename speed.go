@@ -0,0 +1,152 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	speedUnits      = flag.String("speed-units", "kmh", "unit of computed tracker speeds and speed limits: kmh, mph or ms")
+	speedLimit      = flag.Float64("speed-limit", 0, "speed above which a tracker raises a speed-alert event, in -speed-units (0 disables unless set per tracker)")
+	speedHysteresis = flag.Float64("speed-hysteresis", 0.1, "fraction below the limit a tracker must slow to before it can alert again")
+)
+
+// metersPerSecond converts a speed in m/s to each -speed-units unit.
+var metersPerSecond = map[string]float64{
+	"kmh": 3.6,
+	"mph": 2.236936,
+	"ms":  1,
+}
+
+// earthRadius is the mean radius of the Earth in meters.
+const earthRadius = 6371000
+
+// distance returns the great-circle distance between two fixes in meters.
+func distance(a, b GPSLocation) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// speedBetween returns the average speed from prev to loc in -speed-units.
+// It reports false when the fixes are not in time order or their interval
+// cannot be trusted.
+func speedBetween(prev, loc GPSLocation) (float64, bool) {
+	dt, ok := elapsed(prev.UpdatedAt, loc.UpdatedAt)
+	if !ok || dt <= 0 {
+		return 0, false
+	}
+	return distance(prev, loc) / dt.Seconds() * metersPerSecond[*speedUnits], true
+}
+
+var (
+	speedMutex    sync.Mutex
+	trackerLimits = make(map[string]float64) // per-tracker overrides of -speed-limit
+	speeding      = make(map[string]bool)    // trackers that have alerted and not yet slowed down
+)
+
+// limitFor returns the speed limit that applies to id, 0 meaning none.
+// The caller must hold speedMutex.
+func limitFor(id string) float64 {
+	if limit, ok := trackerLimits[id]; ok {
+		return limit
+	}
+	return *speedLimit
+}
+
+// checkSpeed raises a speed-alert when the move from prev to loc exceeds the
+// tracker's limit. A tracker alerts once per excursion: it must drop
+// -speed-hysteresis below the limit before it can alert again.
+func checkSpeed(prev, loc GPSLocation) {
+	speed, ok := speedBetween(prev, loc)
+	if !ok {
+		return
+	}
+
+	speedMutex.Lock()
+	limit := limitFor(loc.ID)
+	alert := limit > 0 && speed > limit && !speeding[loc.ID]
+	switch {
+	case alert:
+		speeding[loc.ID] = true
+	case limit <= 0 || speed < limit*(1-*speedHysteresis):
+		delete(speeding, loc.ID)
+	}
+	speedMutex.Unlock()
+
+	if !alert {
+		return
+	}
+	logMsg := fmt.Sprintf("%s is moving at %.1f %s, over the limit of %g", loc.ID, speed, *speedUnits, limit)
+	log.Println(logMsg)
+	broadcastMessage(SSEMessage{
+		Type:    "speed-alert",
+		ID:      loc.ID,
+		Speed:   &speed,
+		Unit:    *speedUnits,
+		Message: logMsg,
+		Channel: channelGPS,
+		Time:    time.Now(),
+	})
+}
+
+// forgetSpeed drops the speed state of removed trackers.
+func forgetSpeed(ids []string) {
+	speedMutex.Lock()
+	for _, id := range ids {
+		delete(speeding, id)
+	}
+	speedMutex.Unlock()
+}
+
+// speedLimitsHandler lists the speed limits on GET and changes one on POST:
+// ?limit= sets the global limit, or that of ?id= when given. A per-tracker
+// limit of "default" removes the override; 0 disables alerts for it.
+func speedLimitsHandler(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		id := r.URL.Query().Get("id")
+		l := r.URL.Query().Get("limit")
+		if l == "" {
+			return missingParam("limit")
+		}
+		speedMutex.Lock()
+		if id != "" && l == "default" {
+			delete(trackerLimits, id)
+		} else if limit, err := strconv.ParseFloat(l, 64); err != nil || limit < 0 || math.IsNaN(limit) || math.IsInf(limit, 0) {
+			speedMutex.Unlock()
+			return invalidParam("limit")
+		} else if id != "" {
+			trackerLimits[id] = limit
+		} else {
+			*speedLimit = limit
+		}
+		speedMutex.Unlock()
+		log.Printf("Speed limit for %s set to %s", cmp.Or(id, "all trackers"), l)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		return newAPIError(http.StatusMethodNotAllowed, codeBadMethod, "Use GET or POST")
+	}
+
+	speedMutex.Lock()
+	trackers := maps.Clone(trackerLimits)
+	global := *speedLimit
+	speedMutex.Unlock()
+
+	return writeJSON(w, r, map[string]any{
+		"unit":     *speedUnits,
+		"limit":    global,
+		"trackers": trackers,
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSpeedLimitValues(t *testing.T) {
+	setFlag(t, speedLimit, 0)
+	t.Cleanup(func() {
+		speedMutex.Lock()
+		clear(trackerLimits)
+		speedMutex.Unlock()
+	})
+	h := apiHandler(speedLimitsHandler)
+	for _, tc := range []struct {
+		limit string
+		want  int
+	}{
+		{"90", http.StatusOK},
+		{"0", http.StatusOK},
+		{"12.5", http.StatusOK},
+		{"-1", http.StatusBadRequest},
+		{"NaN", http.StatusBadRequest},
+		{"nan", http.StatusBadRequest},
+		{"Inf", http.StatusBadRequest},
+		{"-Inf", http.StatusBadRequest},
+		{"fast", http.StatusBadRequest},
+	} {
+		for _, id := range []string{"", "van-3"} {
+			w := serve(h, http.MethodPost, "/speed-limits?id="+id+"&limit="+tc.limit, nil)
+			if w.Code != tc.want {
+				t.Errorf("id=%q limit=%s: status %d, want %d", id, tc.limit, w.Code, tc.want)
+			}
+		}
+	}
+	speedMutex.Lock()
+	defer speedMutex.Unlock()
+	if *speedLimit != 12.5 || trackerLimits["van-3"] != 12.5 {
+		t.Errorf("limits = %g and %g, want the last valid value 12.5 for both", *speedLimit, trackerLimits["van-3"])
+	}
+}
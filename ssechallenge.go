@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	sseAuthChallenge = flag.Bool("sse-auth-challenge", false, "authenticate SSE clients without a key by a challenge answered via POST /events/auth")
+	sseAuthWindow    = flag.Duration("sse-auth-window", 10*time.Second, "how long an SSE client has to answer its auth challenge")
+)
+
+// pendingChallenges maps an outstanding challenge token to the channel that
+// releases the waiting SSE connection.
+var pendingChallenges sync.Map // string -> chan struct{}
+
+func newChallengeToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// awaitSSEAuth sends an auth-challenge event carrying a one-time token and
+// blocks until the token is redeemed at /events/auth. It reports false, after
+// telling the client why, if the window lapses or the client goes away.
+func awaitSSEAuth(w http.ResponseWriter, r *http.Request, rc *http.ResponseController, c *client) bool {
+	token := newChallengeToken()
+	done := make(chan struct{})
+	pendingChallenges.Store(token, done)
+	defer pendingChallenges.Delete(token)
+
+	writeControlEvent(w, rc, c, SSEMessage{Type: "auth-challenge", Message: token, Time: time.Now()})
+
+	timer := time.NewTimer(*sseAuthWindow)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		writeControlEvent(w, rc, c, SSEMessage{Type: "auth-failed", Message: "Challenge not answered in time", Time: time.Now()})
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// writeControlEvent sends msg to c alone, bypassing the broker and history.
+// It is renamed and encoded as a broadcast would be for c, but skips c's
+// filters since it concerns the connection itself.
+func writeControlEvent(w http.ResponseWriter, rc *http.ResponseController, c *client, msg SSEMessage) error {
+	extendWriteDeadline(rc)
+	event, _ := json.Marshal(msg)
+	data, err := encoding{c.profile, c.cloudEvents}.encode(msg, renameEvent(event))
+	if err != nil {
+		return err
+	}
+	if err := writeEvent(w, encodedEvent{data: data}); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+// sseAuthHandler redeems a challenge token. It sits behind requireAPIKey, so
+// reaching it proves the caller holds the key.
+func sseAuthHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return newAPIError(http.StatusMethodNotAllowed, codeBadMethod, "Use POST")
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return missingParam("token")
+	}
+	v, ok := pendingChallenges.LoadAndDelete(token)
+	if !ok {
+		return newAPIError(http.StatusNotFound, codeNotFound, "Unknown or expired token")
+	}
+	close(v.(chan struct{}))
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Authenticated"))
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+)
+
+func statsHandler(w http.ResponseWriter, r *http.Request) error {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	deviceCount := devices.len()
+	trackerCount := gpsLocations.len()
+
+	historyMutex.Lock()
+	historyCount := len(history)
+	historyMutex.Unlock()
+
+	return writeJSON(w, r, map[string]any{
+		"mode":          memoryMode(),
+		"heap_alloc":    ms.HeapAlloc,
+		"goroutines":    runtime.NumGoroutine(),
+		"clients":       broker.ClientCount(),
+		"devices":       deviceCount,
+		"trackers":      trackerCount,
+		"history":       historyCount,
+		"history_limit": historyLimit(),
+		"track_limit":   trackLimit(),
+	})
+}
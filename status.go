@@ -0,0 +1,34 @@
+package main
+
+import "flag"
+
+var stalePresence = flag.String("stale-presence", "keep", "status of a present device whose tracker fix is stale (see -gps-gap): keep (present-stale-gps) or downgrade (absent)")
+
+// Derived statuses of a device, combining its attendance value with the
+// freshness of the tracker sharing its id.
+const (
+	statusPresentLive     = "present-live"      // present with a current fix
+	statusPresentStaleGPS = "present-stale-gps" // present, but the fix is uncertain
+	statusPresentNoGPS    = "present-no-gps"    // present, no tracker with this id
+	statusAbsent          = "absent"
+)
+
+// deviceStatus reconciles dev's value with the tracker of the same id. A fix
+// counts as stale once -gps-gap has marked it uncertain; with
+// -stale-presence=downgrade such a device is reported absent.
+func deviceStatus(dev DeviceState) string {
+	if !dev.Value {
+		return statusAbsent
+	}
+	loc, ok := gpsLocations.get(dev.ID)
+	switch {
+	case !ok:
+		return statusPresentNoGPS
+	case !loc.Uncertain:
+		return statusPresentLive
+	case *stalePresence == "downgrade":
+		return statusAbsent
+	default:
+		return statusPresentStaleGPS
+	}
+}
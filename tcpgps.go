@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var tcpGPSPort = flag.Int("tcp-gps-port", 0, "also accept newline-delimited id,lat,lon GPS lines on this raw TCP port (0 disables)")
+
+// serveTCPGPS accepts raw TCP connections from trackers that cannot speak
+// HTTP. Each line is "id,lat,lon" and is fed through recordGPS. It returns
+// once ctx is cancelled and every connection has been closed.
+func serveTCPGPS(ctx context.Context, addr string) error {
+	ln, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Accepting TCP GPS lines on %s", ln.Addr())
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		conns = make(map[net.Conn]struct{})
+	)
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				wg.Wait()
+				return nil
+			}
+			warnf("TCP GPS accept error: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handleTCPGPS(conn)
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+		}()
+	}
+}
+
+func handleTCPGPS(conn net.Conn) {
+	defer conn.Close()
+	remote := conn.RemoteAddr().String()
+	log.Printf("TCP GPS client connected: %s", remote)
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		p, err := parseGPSLine(line)
+		if err != nil {
+			log.Printf("TCP GPS %s: skipping %q: %v", remote, line, err)
+			continue
+		}
+		if err := recordGPS(context.Background(), p.location()); err != nil {
+			log.Printf("TCP GPS %s: %v", remote, err)
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		warnf("TCP GPS client %s read error: %v", remote, err)
+	}
+	log.Printf("TCP GPS client disconnected: %s", remote)
+}
+
+// parseGPSLine parses an "id,lat,lon" line, validating it like /gps.
+func parseGPSLine(line string) (writeParams, error) {
+	parts := strings.Split(line, ",")
+	if len(parts) != 3 {
+		return writeParams{}, fmt.Errorf("want 3 fields, got %d", len(parts))
+	}
+	return validate(url.Values{
+		"id":  {strings.TrimSpace(parts[0])},
+		"lat": {strings.TrimSpace(parts[1])},
+		"lon": {strings.TrimSpace(parts[2])},
+	}, gpsValidators)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"time"
+)
+
+var requestTimeout = flag.Duration("request-timeout", 30*time.Second, "cancel non-streaming requests that run longer than this and answer 503 (0 disables)")
+
+const codeTimeout = "timeout"
+
+// withTimeout bounds how long next may run. The request context is cancelled
+// at the deadline so downstream work can stop, and the client gets a JSON
+// error instead of waiting on a hung handler. It buffers the response, so it
+// must not wrap streaming endpoints.
+func withTimeout(d time.Duration, next http.Handler) http.Handler {
+	if d <= 0 {
+		return next
+	}
+	body, _ := json.Marshal(newAPIError(http.StatusServiceUnavailable, codeTimeout, "Request timed out"))
+	th := http.TimeoutHandler(next, d, string(body))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		th.ServeHTTP(&timeoutWriter{ResponseWriter: w}, r)
+	})
+}
+
+// timeoutWriter labels TimeoutHandler's own 503 body as JSON. A handler
+// that finishes in time has its headers copied over before WriteHeader, so
+// a response that already carries a Content-Type is left alone.
+type timeoutWriter struct {
+	http.ResponseWriter
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	if status == http.StatusServiceUnavailable && tw.Header().Get("Content-Type") == "" {
+		tw.Header().Set("Content-Type", "application/json")
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Only the timeout reply is labelled as JSON; a handler that answers in
+// time keeps the Content-Type of what it wrote.
+func TestTimeoutContentType(t *testing.T) {
+	text := withTimeout(time.Second, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Device x set to true")
+	}))
+	// The server, not a recorder, sniffs the type of an unlabelled body.
+	srv := httptest.NewServer(text)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("text handler: status %d, Content-Type %q, want 200 text/plain", resp.StatusCode, ct)
+	}
+
+	errs := withTimeout(time.Second, apiHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return newAPIError(http.StatusServiceUnavailable, codeOverloaded, "busy")
+	}))
+	w := serve(errs, http.MethodGet, "/update", nil)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("handler error: Content-Type %q, want application/json", ct)
+	}
+
+	hung := withTimeout(10*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	w = serve(hung, http.MethodGet, "/update", nil)
+	if ct := w.Header().Get("Content-Type"); w.Code != http.StatusServiceUnavailable || ct != "application/json" || !strings.Contains(w.Body.String(), codeTimeout) {
+		t.Errorf("timeout: status %d, Content-Type %q, body %q", w.Code, ct, w.Body)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"sync/atomic"
+	"time"
+)
+
+var deliveryTiming = flag.Bool("delivery-timing", false, "time each event from broadcast to the write to every SSE client, for /metrics and /debug/state")
+
+// deliveryDurations is keyed by pipeline stage: fanout is broadcast until
+// the event is queued for a client, write is the time it then waits in the
+// client's queue until it has been flushed to the connection, and total is
+// both.
+var deliveryDurations = newHistogramVec("sse_delivery_duration_seconds", "Time from broadcasting an event to writing it to an SSE client, by stage.", "stage")
+
+// stampBroadcast marks msg with the time it was broadcast, once.
+func stampBroadcast(msg *SSEMessage) {
+	if *deliveryTiming && msg.sent.IsZero() {
+		msg.sent = time.Now()
+	}
+}
+
+// clientLatency accumulates one client's delivery latencies, in
+// nanoseconds from broadcast to flush.
+type clientLatency struct {
+	count atomic.Int64
+	sum   atomic.Int64
+	max   atomic.Int64
+	last  atomic.Int64
+}
+
+// observe records the delivery of ev to c, just flushed.
+func (l *clientLatency) observe(ev encodedEvent) {
+	if ev.sent.IsZero() {
+		return
+	}
+	now := time.Now()
+	total := now.Sub(ev.sent)
+	deliveryDurations.observe("fanout", ev.queued.Sub(ev.sent))
+	deliveryDurations.observe("write", now.Sub(ev.queued))
+	deliveryDurations.observe("total", total)
+
+	l.count.Add(1)
+	l.sum.Add(int64(total))
+	l.last.Store(int64(total))
+	for {
+		prev := l.max.Load()
+		if int64(total) <= prev || l.max.CompareAndSwap(prev, int64(total)) {
+			break
+		}
+	}
+}
+
+// latencyState is a client's delivery latency as shown by /debug/state.
+type latencyState struct {
+	Last string `json:"last"`
+	Avg  string `json:"avg"`
+	Max  string `json:"max"`
+}
+
+// state summarizes l, or returns nil before anything was timed.
+func (l *clientLatency) state() *latencyState {
+	n := l.count.Load()
+	if n == 0 {
+		return nil
+	}
+	return &latencyState{
+		Last: time.Duration(l.last.Load()).String(),
+		Avg:  time.Duration(l.sum.Load() / n).String(),
+		Max:  time.Duration(l.max.Load()).String(),
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var otelEndpoint = flag.String("otel-endpoint", "", "OTLP/HTTP collector URL, e.g. http://localhost:4318, to export tracing spans to (tracing is off when unset)")
+
+// tracer is a no-op until setupTracing installs a real provider, so spans
+// cost next to nothing when tracing is disabled.
+var tracer = otel.Tracer("esp32-api")
+
+// setupTracing starts exporting spans to -otel-endpoint and accepts W3C
+// traceparent headers from callers. The returned func flushes and stops the
+// exporter.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(*otelEndpoint))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("esp32-api"), semconv.ServiceVersion(version))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	tracer = provider.Tracer("esp32-api")
+	return provider.Shutdown, nil
+}
+
+// withTracing wraps each request in a server span, continuing the trace of
+// an incoming traceparent header.
+func withTracing(next http.Handler) http.Handler {
+	if *otelEndpoint == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRequestMethodKey.String(r.Method), semconv.URLPath(r.URL.Path)))
+		defer span.End()
+
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r.WithContext(ctx))
+		status := sr.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// startFanout opens the span covering the fan-out of msg, as a child of the
+// request that produced it. The span is a no-op for untraced events.
+func startFanout(msg SSEMessage) trace.Span {
+	if !msg.span.IsValid() {
+		return trace.SpanFromContext(context.Background())
+	}
+	ctx := trace.ContextWithSpanContext(context.Background(), msg.span)
+	_, span := tracer.Start(ctx, "fanout "+msg.Type, trace.WithAttributes(attribute.Int64("event.seq", int64(msg.Seq))))
+	return span
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	trackLength = flag.Int("track-length", 100, "number of recent fixes kept per tracker for /track")
+	gpsGap      = flag.Duration("gps-gap", 0, "mark a tracker's last fix uncertain after this long without a new one (0 disables)")
+	trailLength = flag.Int("broadcast-trail", 0, fmt.Sprintf("include the tracker's last N fixes in each gps event, up to %d (0 sends only the new position)", maxTrail))
+)
+
+// maxTrail caps -broadcast-trail to keep gps events small.
+const maxTrail = 50
+
+// trailPoint is one earlier fix carried in a gps event.
+type trailPoint struct {
+	Lat  float64   `json:"lat"`
+	Lon  float64   `json:"lon"`
+	Time time.Time `json:"time"`
+}
+
+// tracks holds the recent fixes of each tracker, oldest first. It is guarded
+// by gpsMutex, which also serializes writes to gpsLocations.
+var tracks = make(map[string][]GPSLocation)
+
+// appendTrack records loc as the newest fix of its tracker. The caller must
+// hold gpsMutex.
+func appendTrack(loc GPSLocation) {
+	track := append(tracks[loc.ID], loc)
+	if n := trackLimit(); len(track) > n {
+		track = track[len(track)-n:]
+	}
+	tracks[loc.ID] = track
+}
+
+// recentTrail returns the last -broadcast-trail fixes of id, oldest first and
+// ending with the newest, or nil when trails are disabled. The caller must
+// hold gpsMutex.
+func recentTrail(id string) []trailPoint {
+	n := min(*trailLength, maxTrail)
+	if n <= 0 {
+		return nil
+	}
+	track := tracks[id]
+	track = track[max(len(track)-n, 0):]
+	trail := make([]trailPoint, len(track))
+	for i, loc := range track {
+		trail[i] = trailPoint{loc.Lat, loc.Lon, loc.UpdatedAt}
+	}
+	return trail
+}
+
+// markUncertain flags the last fix of id as uncertain in both the current
+// position and the track. It reports false if it was already flagged. The
+// caller must hold gpsMutex.
+func markUncertain(id string) bool {
+	loc, ok := gpsLocations.get(id)
+	if !ok || loc.Uncertain {
+		return false
+	}
+	loc.Uncertain = true
+	gpsLocations.set(id, loc)
+	if track := tracks[id]; len(track) > 0 {
+		track[len(track)-1].Uncertain = true
+	}
+	return true
+}
+
+// gapMessage describes a tracker whose last fix was marked uncertain.
+func gapMessage(id string, last time.Time) string {
+	return fmt.Sprintf("Last fix for %s marked uncertain after %s without updates", id, time.Since(last).Round(time.Second))
+}
+
+// sweepGaps periodically marks the last fix of every tracker that has gone
+// quiet for longer than -gps-gap, so consumers can treat the end of a track
+// as unreliable. It runs until ctx is cancelled.
+func sweepGaps(ctx context.Context, gap time.Duration) {
+	ticker := time.NewTicker(max(gap/4, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cutoff := time.Now().Add(-gap)
+
+		var gone []GPSLocation
+		gpsMutex.Lock()
+		for id, loc := range gpsLocations.snapshot() {
+			if loc.UpdatedAt.Before(cutoff) && markUncertain(id) {
+				gone = append(gone, loc)
+			}
+		}
+		gpsMutex.Unlock()
+
+		for _, loc := range gone {
+			logMsg := gapMessage(loc.ID, loc.UpdatedAt)
+			log.Println(logMsg)
+			broadcastFor(loc.ID, "gps-uncertain", logMsg)
+		}
+	}
+}
+
+func trackHandler(w http.ResponseWriter, r *http.Request) error {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return missingParam("id")
+	}
+
+	offset := 0
+	if o := r.URL.Query().Get("offset"); o != "" {
+		var err error
+		if offset, err = strconv.Atoi(o); err != nil || offset < 0 {
+			return invalidParam("offset")
+		}
+	}
+	limit, err := queryLimit(r)
+	if err != nil {
+		return err
+	}
+
+	gpsMutex.Lock()
+	all := tracks[id][min(offset, len(tracks[id])):]
+	track := make([]GPSLocation, min(limit, len(all)))
+	copy(track, all)
+	gpsMutex.Unlock()
+	if len(all) > limit {
+		markTruncated(w, strconv.Itoa(offset+limit))
+	}
+
+	out, err := formatLocations(r, track)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, r, out)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+)
+
+var transformFile = flag.String("transform-file", "", "JSON file of named event transform profiles selectable with /events?profile=")
+
+// transformProfile rewrites outgoing events for subscribers that ask for it.
+// Steps run in a fixed order: drop, round, rename, then set.
+type transformProfile struct {
+	Drop   []string          `json:"drop"`   // fields to remove
+	Round  map[string]int    `json:"round"`  // numeric field -> decimal places
+	Rename map[string]string `json:"rename"` // old field name -> new field name
+	Set    map[string]any    `json:"set"`    // constant fields to add
+}
+
+// transformProfiles holds the profiles loaded from -transform-file.
+var transformProfiles = map[string]*transformProfile{}
+
+func loadTransformProfiles(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	profiles := map[string]*transformProfile{}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for name, p := range profiles {
+		if p == nil {
+			return fmt.Errorf("profile %q is empty", name)
+		}
+	}
+	transformProfiles = profiles
+	return nil
+}
+
+// apply returns the transformed encoding of event. A nil profile is the
+// identity transform.
+func (p *transformProfile) apply(event []byte) ([]byte, error) {
+	if p == nil {
+		return event, nil
+	}
+
+	var obj map[string]any
+	if err := json.Unmarshal(event, &obj); err != nil {
+		return nil, err
+	}
+
+	for _, field := range p.Drop {
+		delete(obj, field)
+	}
+	for field, places := range p.Round {
+		if f, ok := obj[field].(float64); ok {
+			scale := math.Pow(10, float64(places))
+			obj[field] = math.Round(f*scale) / scale
+		}
+	}
+	for from, to := range p.Rename {
+		if val, ok := obj[from]; ok {
+			delete(obj, from)
+			obj[to] = val
+		}
+	}
+	for field, val := range p.Set {
+		obj[field] = val
+	}
+
+	return json.Marshal(obj)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// writeParams is the validated content of one device update or GPS fix.
+type writeParams struct {
+	ID     string
+	Value  bool
+	Lat    float64
+	Lon    float64
+	Source string
+	Reason string
+	TS     time.Time
+	TTL    time.Duration
+}
+
+// A validator checks one aspect of a write and fills in its part of p. It
+// reads the same fields whether they came from a query string or a batch
+// entry, so every write path rejects bad input with the same error.
+type validator func(q url.Values, p *writeParams) error
+
+// The validators of each kind of write, in the order they run. A new check
+// added here applies to the single and the batch endpoint alike.
+// Authentication, nonces and rate limits are middleware and run first.
+var (
+	deviceValidators = []validator{requireID, deviceValue, changeReason, clientTime, entryTTL}
+	gpsValidators    = []validator{requireID, coordinates, gpsSource, clientTime, entryTTL}
+)
+
+// validate runs validators over q in order, stopping at the first error.
+func validate(q url.Values, validators []validator) (writeParams, error) {
+	var p writeParams
+	for _, v := range validators {
+		if err := v(q, &p); err != nil {
+			return writeParams{}, err
+		}
+	}
+	return p, nil
+}
+
+func requireID(q url.Values, p *writeParams) error {
+	if p.ID = q.Get("id"); p.ID == "" {
+		return missingParam("id")
+	}
+	return nil
+}
+
+func deviceValue(q url.Values, p *writeParams) error {
+	val := q.Get("value")
+	if val == "" {
+		return missingParam("value")
+	}
+	parsed, err := parseDeviceValue(val)
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, codeInvalidParam, "Invalid boolean value")
+	}
+	p.Value = parsed
+	return nil
+}
+
+// reasonCode is the form of an update's ?reason=, such as manual or
+// badge_scan. Automatic changes use their own codes, such as timeout.
+var reasonCode = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+func changeReason(q url.Values, p *writeParams) error {
+	if p.Reason = q.Get("reason"); p.Reason == "" {
+		p.Reason = "manual"
+	}
+	if !reasonCode.MatchString(p.Reason) {
+		return invalidParam("reason")
+	}
+	return nil
+}
+
+func coordinates(q url.Values, p *writeParams) error {
+	var ok bool
+	if p.Lat, ok = parseCoordinate(q.Get("lat"), 90); !ok {
+		return invalidParam("lat")
+	}
+	if p.Lon, ok = parseCoordinate(q.Get("lon"), 180); !ok {
+		return invalidParam("lon")
+	}
+	return nil
+}
+
+// parseCoordinate parses a latitude or longitude within [-limit, limit].
+func parseCoordinate(s string, limit float64) (float64, bool) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || !validCoordinate(v, limit) {
+		return 0, false
+	}
+	return v, true
+}
+
+// validCoordinate reports whether v is a latitude or longitude within
+// [-limit, limit]. NaN and infinities are rejected too: they cannot be
+// encoded as JSON, so one stored fix would break every read endpoint and
+// the state file.
+func validCoordinate(v, limit float64) bool {
+	return !math.IsNaN(v) && v >= -limit && v <= limit
+}
+
+func gpsSource(q url.Values, p *writeParams) error {
+	p.Source = q.Get("source")
+	return nil
+}
+
+func clientTime(q url.Values, p *writeParams) (err error) {
+	p.TS, err = parseUpdateTime(q.Get("ts"))
+	return err
+}
+
+func entryTTL(q url.Values, p *writeParams) (err error) {
+	p.TTL, err = parseTTL(q.Get("ttl"))
+	return err
+}
+
+func (p writeParams) device() DeviceState {
+	return DeviceState{ID: p.ID, Value: p.Value, UpdatedAt: p.TS, Reason: p.Reason, TTL: p.TTL}
+}
+
+func (p writeParams) location() GPSLocation {
+	return GPSLocation{ID: p.ID, Lat: p.Lat, Lon: p.Lon, Source: p.Source, UpdatedAt: p.TS, TTL: p.TTL}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCoordinates(t *testing.T) {
+	tests := []struct {
+		lat, lon string
+		wantErr  string // param named in the error, empty when valid
+	}{
+		{"52.52", "13.405", ""},
+		{"-90", "-180", ""},
+		{"90", "180", ""},
+		{"0", "0", ""},
+		{"90.000001", "0", "lat"},
+		{"-91", "0", "lat"},
+		{"0", "180.5", "lon"},
+		{"0", "999", "lon"},
+		{"1e308", "0", "lat"},
+		{"NaN", "0", "lat"},
+		{"0", "NaN", "lon"},
+		{"Inf", "1", "lat"},
+		{"-Inf", "1", "lat"},
+		{"0", "+Inf", "lon"},
+		{"", "0", "lat"},
+		{"0", "abc", "lon"},
+	}
+	for _, tt := range tests {
+		_, err := validate(url.Values{"id": {"a"}, "lat": {tt.lat}, "lon": {tt.lon}}, gpsValidators)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("lat=%s lon=%s: unexpected error %v", tt.lat, tt.lon, err)
+		case tt.wantErr != "" && (err == nil || err.Error() != "Invalid "+tt.wantErr+" param"):
+			t.Errorf("lat=%s lon=%s: got %v, want invalid %s", tt.lat, tt.lon, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseGPSLineRange(t *testing.T) {
+	for _, line := range []string{"a,Inf,1", "a,NaN,1", "a,91,0", "a,0,-181"} {
+		if _, err := parseGPSLine(line); err == nil {
+			t.Errorf("parseGPSLine(%q) accepted an invalid coordinate", line)
+		}
+	}
+	p, err := parseGPSLine(" t1 , 52.5 , 13.4 ")
+	if err != nil || p.ID != "t1" || p.Lat != 52.5 || p.Lon != 13.4 {
+		t.Errorf("parseGPSLine = %+v, %v", p, err)
+	}
+}
+
+// The single endpoints and the batch entries share the validators, so the
+// same input must fail the same way on both.
+func TestValidationMatchesAcrossPaths(t *testing.T) {
+	resetState(t)
+	gps := []struct{ query, body string }{
+		{"lat=Inf&lon=1", `{"id":"a","lon":1}`},
+		{"lat=91&lon=1", `{"id":"a","lat":91,"lon":1}`},
+		{"lat=1&lon=181", `{"id":"a","lat":1,"lon":181}`},
+		{"lat=1", `{"id":"a","lat":1}`},
+	}
+	for _, tt := range gps {
+		single := serve(apiHandler(gpsHandler), http.MethodGet, "/gps?id=a&"+tt.query, nil)
+		batch := serve(apiHandler(gpsBatchHandler), http.MethodPost, "/gps/batch", strings.NewReader("["+tt.body+"]"))
+		var apiErr apiError
+		json.Unmarshal(single.Body.Bytes(), &apiErr)
+		var res batchResult
+		json.Unmarshal(batch.Body.Bytes(), &res)
+		if single.Code != http.StatusBadRequest || len(res.Errors) != 1 || res.Errors[0].Error != apiErr.Message {
+			t.Errorf("%s: single %d %q, batch %+v", tt.query, single.Code, apiErr.Message, res.Errors)
+		}
+	}
+
+	devices := []struct{ query, body string }{
+		{"value=maybe", `{"id":"d","value":"maybe"}`},
+		{"value=true&reason=Not%20A%20Code", `{"id":"d","value":true,"reason":"Not A Code"}`},
+		{"value=true&ttl=-1s", `{"id":"d","value":true,"ttl":"-1s"}`},
+	}
+	for _, tt := range devices {
+		single := serve(apiHandler(updateHandler), http.MethodGet, "/update?id=d&"+tt.query, nil)
+		batch := serve(apiHandler(updateBatchHandler), http.MethodPost, "/update/batch", strings.NewReader("["+tt.body+"]"))
+		var apiErr apiError
+		json.Unmarshal(single.Body.Bytes(), &apiErr)
+		var res batchResult
+		json.Unmarshal(batch.Body.Bytes(), &res)
+		if single.Code != http.StatusBadRequest || len(res.Errors) != 1 || res.Errors[0].Error != apiErr.Message {
+			t.Errorf("%s: single %d %q, batch %+v", tt.query, single.Code, apiErr.Message, res.Errors)
+		}
+	}
+	if devices, locs := deviceList(), locationList(); len(devices) != 0 || len(locs) != 0 {
+		t.Errorf("rejected writes were stored: %v %v", devices, locs)
+	}
+}
+
+func TestValidWritesAreStored(t *testing.T) {
+	resetState(t)
+	if w := serve(apiHandler(gpsHandler), http.MethodGet, "/gps?id=a&lat=-90&lon=180", nil); w.Code != http.StatusOK {
+		t.Fatalf("/gps: %d %s", w.Code, w.Body)
+	}
+	if w := serve(apiHandler(updateHandler), http.MethodGet, "/update?id=d&value=true&reason=badge_scan", nil); w.Code != http.StatusOK {
+		t.Fatalf("/update: %d %s", w.Code, w.Body)
+	}
+	loc, _ := gpsLocations.get("a")
+	dev, _ := devices.get("d")
+	if loc.Lat != -90 || loc.Lon != 180 || !dev.Value || dev.Reason != "badge_scan" {
+		t.Errorf("stored %+v and %+v", loc, dev)
+	}
+	w := serve(apiHandler(locationsHandler), http.MethodGet, "/locations", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"lat":-90`) {
+		t.Errorf("/locations: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestWriteJSONReportsEncodeErrors(t *testing.T) {
+	w := serve(apiHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return writeJSON(w, r, map[string]float64{"x": math.Inf(1)})
+	}), http.MethodGet, "/", nil)
+	if w.Code != http.StatusInternalServerError || w.Body.Len() == 0 {
+		t.Errorf("got %d %q, want a 500 error body", w.Code, w.Body)
+	}
+}
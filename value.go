@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+)
+
+var valueTokens = flag.String("value-tokens", "strict", "accepted ?value= spellings: strict (strconv.ParseBool) or extended (also on/off, yes/no, present/absent, in/out)")
+
+// extendedValues are the additional spellings accepted with
+// -value-tokens=extended, matched case-insensitively.
+var extendedValues = map[string]bool{
+	"on": true, "off": false,
+	"yes": true, "no": false,
+	"present": true, "absent": false,
+	"in": true, "out": false,
+}
+
+// parseDeviceValue parses a device's reported value. ParseBool spellings are
+// always accepted.
+func parseDeviceValue(s string) (bool, error) {
+	v, err := strconv.ParseBool(s)
+	if err == nil || *valueTokens != "extended" {
+		return v, err
+	}
+	if v, ok := extendedValues[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	return false, err
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestParseDeviceValue(t *testing.T) {
+	type result struct {
+		ok, value bool
+	}
+	accept := func(v bool) result { return result{true, v} }
+	reject := result{}
+	for _, tc := range []struct {
+		token            string
+		strict, extended result
+	}{
+		// strconv.ParseBool spellings, accepted in both modes.
+		{"1", accept(true), accept(true)},
+		{"t", accept(true), accept(true)},
+		{"T", accept(true), accept(true)},
+		{"true", accept(true), accept(true)},
+		{"TRUE", accept(true), accept(true)},
+		{"True", accept(true), accept(true)},
+		{"0", accept(false), accept(false)},
+		{"f", accept(false), accept(false)},
+		{"F", accept(false), accept(false)},
+		{"false", accept(false), accept(false)},
+		{"FALSE", accept(false), accept(false)},
+		{"False", accept(false), accept(false)},
+
+		// Extended spellings, matched case-insensitively.
+		{"on", reject, accept(true)},
+		{"ON", reject, accept(true)},
+		{"off", reject, accept(false)},
+		{"Off", reject, accept(false)},
+		{"yes", reject, accept(true)},
+		{"YES", reject, accept(true)},
+		{"no", reject, accept(false)},
+		{"No", reject, accept(false)},
+		{"present", reject, accept(true)},
+		{"Present", reject, accept(true)},
+		{"absent", reject, accept(false)},
+		{"ABSENT", reject, accept(false)},
+		{"in", reject, accept(true)},
+		{"IN", reject, accept(true)},
+		{"out", reject, accept(false)},
+		{"Out", reject, accept(false)},
+
+		// Rejected in both modes.
+		{"", reject, reject},
+		{"2", reject, reject},
+		{"-1", reject, reject},
+		{"tRUE", reject, reject},
+		{" true", reject, reject},
+		{"true ", reject, reject},
+		{"y", reject, reject},
+		{"n", reject, reject},
+		{"enabled", reject, reject},
+		{"onn", reject, reject},
+		{"inside", reject, reject},
+	} {
+		for mode, want := range map[string]result{"strict": tc.strict, "extended": tc.extended} {
+			setFlag(t, valueTokens, mode)
+			v, err := parseDeviceValue(tc.token)
+			if got := (result{err == nil, v}); got != want {
+				t.Errorf("%s %q: got value %v, error %v; want %+v", mode, tc.token, v, err, want)
+			}
+		}
+	}
+}
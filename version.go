@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"runtime/debug"
+)
+
+// version can be set at build time with -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
+// buildHash identifies the embedded dashboard. It changes whenever
+// index.html changes, so it doubles as the page's ETag and lets an open
+// dashboard notice that the server was upgraded.
+var buildHash = contentHash(indexHTML)
+
+func contentHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// instanceID is a random token generated once per process. A client that
+// sees it change knows the server restarted, and with it the event sequence
+// and history, and should resync.
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withInstance adds X-Server-Instance to every response.
+func withInstance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Server-Instance", instanceID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// vcsRevision returns the commit the binary was built from, if recorded.
+func vcsRevision() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return ""
+}
+
+func versionHandler(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, r, map[string]string{
+		"version":  version,
+		"build":    buildHash,
+		"revision": vcsRevision(),
+		"instance": instanceID,
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//go:embed view.html
+var viewHTML string
+
+var viewTemplate = template.Must(template.New("view").Parse(viewHTML))
+
+// viewHandler renders the current devices and positions as a plain HTML
+// table that reloads itself every ?refresh= seconds (default 10), for kiosk
+// browsers that cannot run the dashboard's JavaScript.
+func viewHandler(w http.ResponseWriter, r *http.Request) error {
+	refresh := 10
+	if s := r.URL.Query().Get("refresh"); s != "" {
+		var err error
+		if refresh, err = strconv.Atoi(s); err != nil || refresh <= 0 {
+			return invalidParam("refresh")
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return viewTemplate.Execute(w, map[string]any{
+		"Devices":   deviceList(),
+		"Locations": locationList(),
+		"Refresh":   refresh,
+		"Generated": time.Now(),
+	})
+}
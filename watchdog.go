@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	quietWindow  = flag.Duration("quiet-window", 0, "broadcast system-quiet when no /update or /gps request arrives within this window (0 disables)")
+	alertWebhook = flag.String("alert-webhook", "", "URL that receives a JSON POST when the server goes quiet or becomes active again")
+)
+
+var (
+	lastInbound atomic.Int64 // sinceStart of the last write request
+	quiet       atomic.Bool
+)
+
+// trackInbound records the arrival of every write request for the quiet
+// watchdog, and announces that traffic has resumed after a quiet period.
+func trackInbound(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastInbound.Store(int64(sinceStart()))
+		if quiet.CompareAndSwap(true, false) {
+			announce("system-active", "Traffic resumed")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// watchQuiet broadcasts system-quiet once no write request has arrived for
+// window, which usually means an upstream outage.
+func watchQuiet(window time.Duration) {
+	lastInbound.Store(int64(sinceStart()))
+	for range time.Tick(max(window/4, time.Second)) {
+		since := sinceStart() - time.Duration(lastInbound.Load())
+		if since > window && quiet.CompareAndSwap(false, true) {
+			announce("system-quiet", fmt.Sprintf("No updates received for %s", since.Round(time.Second)))
+		}
+	}
+}
+
+// announce broadcasts a watchdog transition and forwards it to -alert-webhook.
+func announce(msgType, message string) {
+	log.Println(message)
+	msg := SSEMessage{Type: msgType, Message: message, Channel: channelSystem, Time: time.Now()}
+	broadcastMessage(msg)
+	if *alertWebhook != "" {
+		go postAlert(*alertWebhook, msg)
+	}
+}
+
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+func postAlert(url string, msg SSEMessage) {
+	body, _ := json.Marshal(msg)
+	if *cloudEvents {
+		body, _ = toCloudEvent(msg, body)
+	}
+	resp, err := alertClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		warnf("Alert webhook failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		warnf("Alert webhook returned %s", resp.Status)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	webhookURL     = flag.String("webhook-url", "", "POST every event to this URL as JSON")
+	webhookRetries = flag.Int("webhook-retries", 3, "delivery attempts per event before it is recorded as failed")
+	webhookLogSize = flag.Int("webhook-log-size", 200, "number of recent webhook deliveries kept for /webhook/deliveries")
+	webhookTripAt  = flag.Int("webhook-trip-after", 5, "consecutive failed deliveries to a webhook URL after which it is skipped for -webhook-trip-for (0 disables)")
+	webhookTripFor = flag.Duration("webhook-trip-for", 30*time.Second, "how long a webhook URL is skipped once its circuit breaker trips")
+)
+
+// delivery records the outcome of sending one event to -webhook-url or to a
+// device's own webhook.
+type delivery struct {
+	Seq        uint64    `json:"seq,omitempty"`
+	Type       string    `json:"type"`
+	Device     string    `json:"device,omitempty"` // set for deliveries to a device webhook
+	Status     string    `json:"status"`           // delivered, failed or skipped
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+var (
+	deliveries      []delivery
+	deliveriesMutex sync.Mutex
+)
+
+func recordDelivery(d delivery) {
+	deliveriesMutex.Lock()
+	defer deliveriesMutex.Unlock()
+	deliveries = append(deliveries, d)
+	if over := len(deliveries) - *webhookLogSize; over > 0 {
+		deliveries = deliveries[over:]
+	}
+}
+
+// webhookClient does not follow redirects: a receiver could otherwise send
+// deliveries to a host that -webhook-allow-hosts would have refused. A
+// redirect counts as a failed delivery.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// circuit is the breaker state of one webhook URL.
+type circuit struct {
+	failures  int       // consecutive failed deliveries
+	openUntil time.Time // deliveries are skipped until then
+}
+
+var (
+	circuitsMutex sync.Mutex
+	circuits      = make(map[string]*circuit)
+)
+
+// circuitOpen reports whether deliveries to url are currently skipped.
+// Once -webhook-trip-for has passed, one delivery is let through; its
+// outcome closes the breaker or trips it again.
+func circuitOpen(url string) bool {
+	circuitsMutex.Lock()
+	defer circuitsMutex.Unlock()
+	c := circuits[url]
+	return c != nil && time.Now().Before(c.openUntil)
+}
+
+// recordOutcome updates the breaker of url after a delivery.
+func recordOutcome(url string, ok bool) {
+	circuitsMutex.Lock()
+	defer circuitsMutex.Unlock()
+	if ok {
+		delete(circuits, url)
+		return
+	}
+	c := circuits[url]
+	if c == nil {
+		c = &circuit{}
+		circuits[url] = c
+	}
+	c.failures++
+	if *webhookTripAt > 0 && c.failures >= *webhookTripAt {
+		c.openUntil = time.Now().Add(*webhookTripFor)
+		warnf("Webhook %s failed %d time(s) in a row; skipping it for %s", redactURL(url), c.failures, *webhookTripFor)
+	}
+}
+
+// runWebhook forwards every event to url, one at a time so the receiver sees
+// them in order. Events arriving while a delivery is being retried queue in
+// the subscriber buffer and are dropped once it fills, like for SSE clients.
+func runWebhook(url string) {
+	events, _ := broker.subscribe(&client{cloudEvents: *cloudEvents})
+	for ev := range events {
+		deliver(url, delivery{Seq: ev.msg.Seq, Type: ev.msg.Type}, ev.data)
+	}
+}
+
+// deliver posts body to url, retrying up to -webhook-retries times, and
+// records the outcome in d. Nothing is sent while the circuit breaker of url
+// is open.
+func deliver(url string, d delivery, body []byte) {
+	if circuitOpen(url) {
+		d.Status, d.Error, d.Time = "skipped", "circuit breaker open", time.Now()
+		recordDelivery(d)
+		return
+	}
+	for d.Attempts < max(*webhookRetries, 1) {
+		if d.Attempts > 0 {
+			time.Sleep(time.Duration(d.Attempts) * time.Second)
+		}
+		d.Attempts++
+		d.StatusCode, d.Error = postWebhook(url, body)
+		if d.Error == "" {
+			break
+		}
+	}
+	recordOutcome(url, d.Error == "")
+	d.Status = "delivered"
+	if d.Error != "" {
+		d.Status = "failed"
+		warnf("Webhook delivery of %s failed after %d attempt(s): %s", d.Type, d.Attempts, d.Error)
+	}
+	d.Time = time.Now()
+	recordDelivery(d)
+}
+
+// postWebhook sends one event and returns the response code and, if the
+// delivery did not succeed, why.
+func postWebhook(url string, body []byte) (int, string) {
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Sprintf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, ""
+}
+
+// webhookDeliveriesHandler lists the most recent deliveries, newest first.
+func webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) error {
+	limit := *webhookLogSize
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			return invalidParam("limit")
+		}
+		limit = n
+	}
+
+	deliveriesMutex.Lock()
+	out := make([]delivery, 0, min(limit, len(deliveries)))
+	for i := len(deliveries) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, deliveries[i])
+	}
+	deliveriesMutex.Unlock()
+
+	return writeJSON(w, r, out)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lastDelivery returns the newest entry of the delivery log.
+func lastDelivery(t *testing.T) delivery {
+	t.Helper()
+	deliveriesMutex.Lock()
+	defer deliveriesMutex.Unlock()
+	if len(deliveries) == 0 {
+		t.Fatal("no delivery recorded")
+	}
+	return deliveries[len(deliveries)-1]
+}
+
+// A redirect could point a device webhook at a host outside
+// -webhook-allow-hosts, so it must not be followed.
+func TestWebhookIgnoresRedirects(t *testing.T) {
+	setFlag(t, webhookRetries, 1)
+	var internal atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internal.Add(1)
+	}))
+	defer target.Close()
+	hook := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+	defer hook.Close()
+
+	deliver(hook.URL, delivery{Type: "update"}, []byte(`{}`))
+	if n := internal.Load(); n != 0 {
+		t.Errorf("redirect target received %d request(s)", n)
+	}
+	if d := lastDelivery(t); d.Status != "failed" || d.StatusCode != http.StatusFound {
+		t.Errorf("delivery %+v, want failed with 302", d)
+	}
+}
+
+func TestWebhookCircuitBreaker(t *testing.T) {
+	setFlag(t, webhookRetries, 1)
+	setFlag(t, webhookTripAt, 2)
+	setFlag(t, webhookTripFor, 100*time.Millisecond)
+	var hits atomic.Int32
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	for range 4 {
+		deliver(srv.URL, delivery{Type: "update"}, []byte(`{}`))
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("receiver hit %d time(s), want 2 before the breaker trips", n)
+	}
+	if d := lastDelivery(t); d.Status != "skipped" {
+		t.Errorf("delivery while open: %+v, want skipped", d)
+	}
+
+	// After the cooldown one delivery goes through and closes the breaker.
+	time.Sleep(150 * time.Millisecond)
+	healthy.Store(true)
+	deliver(srv.URL, delivery{Type: "update"}, []byte(`{}`))
+	deliver(srv.URL, delivery{Type: "update"}, []byte(`{}`))
+	if n := hits.Load(); n != 4 {
+		t.Errorf("receiver hit %d time(s) after recovery, want 4", n)
+	}
+	if d := lastDelivery(t); d.Status != "delivered" {
+		t.Errorf("delivery after recovery: %+v", d)
+	}
+}
+
+// Device hooks must match ids even when -field-map renames the id key.
+func TestDeviceWebhookWithRenamedID(t *testing.T) {
+	// The hook subscriber lives as long as its broker, so give it one of
+	// its own rather than leave it on the shared broker. The shared one
+	// reads the field names while fanning out, so let it finish first.
+	shared := broker
+	quiesce(t, shared)
+	broker = NewBroker()
+	t.Cleanup(func() { broker = shared })
+
+	setFlag(t, fieldMap, "id=uid,type=kind")
+	if err := parseFieldNames(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fieldName = nil })
+
+	got := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- struct{}{}
+	}))
+	defer srv.Close()
+
+	deviceHooksMutex.Lock()
+	deviceHooks["vip-1"] = srv.URL
+	deviceHooksMutex.Unlock()
+	t.Cleanup(func() {
+		deviceHooksMutex.Lock()
+		delete(deviceHooks, "vip-1")
+		deviceHooksMutex.Unlock()
+	})
+	go runDeviceWebhooks()
+	waitForClients(t, 1)
+
+	broadcastFor("vip-1", "update", "Attendance registered for vip-1")
+	select {
+	case <-got:
+	case <-time.After(2 * time.Second):
+		t.Fatal("device webhook was not called")
+	}
+}
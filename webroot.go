@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var (
+	webroot = flag.String("webroot", "", "serve the dashboard from this directory instead of the embedded index.html")
+	devMode = flag.Bool("dev", false, "watch -webroot and broadcast a reload event when its files change")
+)
+
+// fallbackHTML is served at / when the binary was built without a dashboard,
+// so a misbuild is obvious rather than a blank page.
+const fallbackHTML = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="UTF-8"><title>Dashboard missing</title></head>
+<body>
+<h1>Dashboard missing</h1>
+<p>This server was built without index.html. The API is still available:</p>
+<ul>
+<li><a href="/devices">/devices</a></li>
+<li><a href="/locations">/locations</a></li>
+<li><a href="/history">/history</a></li>
+<li><a href="/events">/events</a></li>
+<li><a href="/stats">/stats</a></li>
+<li><a href="/version">/version</a></li>
+<li><a href="/view">/view</a></li>
+<li><a href="/m">/m</a></li>
+</ul>
+</body>
+</html>
+`
+
+// rootHandler serves the dashboard, either from the embedded page or from
+// the directory given by -webroot, with preload hints for its assets. Other
+// paths get 404, from the file server or from dashboardOnly.
+func rootHandler() http.Handler {
+	if *webroot != "" {
+		return withPreload(nil, http.FileServer(http.Dir(*webroot)))
+	}
+	if len(bytes.TrimSpace(indexHTML)) == 0 {
+		warnf("Embedded index.html is empty; serving a fallback page at /")
+		return dashboardOnly(embeddedPage("index.html", []byte(fallbackHTML)))
+	}
+	return dashboardOnly(withPreload(indexHTML, embeddedPage("index.html", indexHTML)))
+}
+
+// mobileHandler serves the embedded mobile dashboard.
+func mobileHandler() http.Handler {
+	return embeddedPage("mobile.html", mobileHTML)
+}
+
+// embeddedPage serves an HTML page compiled into the binary.
+func embeddedPage(name string, page []byte) http.Handler {
+	etag := `"` + contentHash(page) + `"`
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		// Browsers must revalidate on every load; the ETag makes that a
+		// cheap 304 until the binary is rebuilt with a different page.
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(page))
+	})
+}
+
+// watchWebroot broadcasts a "reload" event whenever a file under dir changes.
+// Bursts of filesystem events (editors often write several) are coalesced.
+func watchWebroot(dir string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// fsnotify is not recursive, so every directory is watched individually.
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		const settle = 100 * time.Millisecond
+		timer := time.NewTimer(settle)
+		timer.Stop()
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Create) {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						watcher.Add(event.Name)
+					}
+				}
+				timer.Reset(settle)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				warnf("Webroot watcher error: %v", err)
+			case <-timer.C:
+				log.Println("Webroot changed, signalling reload")
+				notify(SSEMessage{Type: "reload", Time: time.Now()})
+			}
+		}
+	}()
+	return nil
+}